@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a stringList is a flag that can be given more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// an alertRule is a condition on a result, like status=500,
+// status=5xx, status=401-403, tag=exposed-git or host=example.com
+type alertRule struct {
+	raw      string
+	key      string
+	value    string
+	statuses statusFilter
+}
+
+// parseAlertRule parses an -alert-on condition
+func parseAlertRule(raw string) (alertRule, error) {
+	parts := strings.SplitN(raw, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return alertRule{}, fmt.Errorf("invalid alert condition %q (want key=value)", raw)
+	}
+
+	r := alertRule{raw: raw, key: parts[0], value: parts[1]}
+	switch r.key {
+	case "status":
+		statuses, err := parseStatusFilter(r.value)
+		if err != nil {
+			return alertRule{}, fmt.Errorf("invalid status in alert condition %q", raw)
+		}
+		r.statuses = statuses
+	case "tag", "host":
+	default:
+		return alertRule{}, fmt.Errorf("unknown key in alert condition %q (want status, tag or host)", raw)
+	}
+	return r, nil
+}
+
+// Match reports whether a result matches the rule
+func (r alertRule) Match(host string, status int, tags []string) bool {
+	switch r.key {
+	case "status":
+		return r.statuses.Match(status)
+	case "tag":
+		return contains(tags, r.value)
+	case "host":
+		return strings.EqualFold(host, r.value)
+	}
+	return false
+}
+
+// an alerter posts messages about results that match its
+// rules to a Slack or Discord compatible webhook. Messages
+// are sent one at a time in the background so that slow
+// webhooks don't hold up requests; when too many are waiting
+// to be sent, new ones are dropped rather than waited for
+type alerter struct {
+	webhook string
+	rules   []alertRule
+	client  *http.Client
+
+	messages chan string
+	wg       sync.WaitGroup
+	dropped  int64
+}
+
+// newAlerter returns a new *alerter for a webhook and rules,
+// and starts sending messages
+func newAlerter(webhook string, rules []alertRule) *alerter {
+	a := &alerter{
+		webhook:  webhook,
+		rules:    rules,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		messages: make(chan string, 100),
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for msg := range a.messages {
+			if err := a.send(msg); err != nil {
+				fmt.Printf("failed to send alert: %s\n", err)
+			}
+		}
+	}()
+
+	return a
+}
+
+// Check queues an alert for a result if it
+// matches any of the rules
+func (a *alerter) Check(u, p, host string, status int, tags []string) {
+	var matched []string
+	for _, r := range a.rules {
+		if r.Match(host, status, tags) {
+			matched = append(matched, r.raw)
+		}
+	}
+
+	if len(matched) == 0 {
+		return
+	}
+
+	select {
+	case a.messages <- fmt.Sprintf("concurl: %s matched %s (status %d, saved as %s)", u, strings.Join(matched, ", "), status, p):
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+		fmt.Printf("dropped alert for %s: too many waiting to be sent\n", u)
+	}
+}
+
+// Close waits for the queued alerts to be sent, and
+// returns the number of alerts that were dropped
+func (a *alerter) Close() int {
+	close(a.messages)
+	a.wg.Wait()
+	return int(atomic.LoadInt64(&a.dropped))
+}
+
+// send posts a message to the webhook. Slack reads the
+// text field and Discord reads content, so both are set
+func (a *alerter) send(msg string) error {
+	body, err := json.Marshal(map[string]string{
+		"text":    msg,
+		"content": msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.client.Post(a.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
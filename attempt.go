@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// an attempt records the outcome of a single
+// invocation of curl for a URL
+type attempt struct {
+	start  time.Time
+	status int
+	err    error
+}
+
+// String returns the start time and outcome of the
+// attempt, as recorded in the output file
+func (a attempt) String() string {
+	outcome := fmt.Sprintf("ok (status %d)", a.status)
+	if a.err != nil {
+		outcome = fmt.Sprintf("failed (%s)", a.err)
+	}
+	return fmt.Sprintf("%s %s", a.start.Format(time.RFC3339), outcome)
+}
+
+// failedOutput returns the contents of the output file for
+// a URL whose attempts were all used up, which has no output
+// but records the attempts so that flaky targets can be
+// looked into later
+func failedOutput(cmdLine string, attempts []attempt) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("cmd: curl ")
+	buf.WriteString(cmdLine)
+	buf.WriteString("\n")
+
+	for i, a := range attempts {
+		fmt.Fprintf(buf, "attempt: %d %s\n", i+1, a)
+	}
+	fmt.Fprintf(buf, "body: none (failed after %d attempts)\n", len(attempts))
+
+	buf.WriteString("------\n\n")
+	return buf.Bytes()
+}
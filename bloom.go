@@ -0,0 +1,154 @@
+package main
+
+import (
+	"container/list"
+	"hash/fnv"
+	"math"
+)
+
+// a bloomFilter is a fixed size set that can say an item is
+// definitely not in the set, or that it probably is
+type bloomFilter struct {
+	bits     []uint64
+	m        uint64
+	k        uint64
+	count    uint64
+	capacity uint64
+}
+
+// newBloomFilter returns a *bloomFilter sized to hold capacity
+// items with the provided false positive rate
+func newBloomFilter(capacity uint64, fpRate float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &bloomFilter{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+// bloomHashes returns the two hashes used to derive the
+// bit positions for an item
+func bloomHashes(item string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(item))
+	sum := h.Sum(nil)
+
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[i+8])
+	}
+	return h1, h2 | 1
+}
+
+// Add adds an item to the filter
+func (b *bloomFilter) Add(h1, h2 uint64) {
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+	b.count++
+}
+
+// Test reports whether an item is probably in the filter
+func (b *bloomFilter) Test(h1, h2 uint64) bool {
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// a seenSet remembers which items have been seen in bounded
+// memory. Recently seen items are remembered exactly, and all
+// items are remembered in a scalable bloom filter: a series of
+// bloom filters that grow in size and have tighter false
+// positive rates so that the overall rate stays within the
+// one asked for no matter how many items are added. It is
+// not safe for concurrent use
+type seenSet struct {
+	filters []*bloomFilter
+	fpRate  float64
+
+	recent    map[string]*list.Element
+	order     *list.List
+	maxRecent int
+}
+
+// newSeenSet returns a new *seenSet with the provided
+// overall false positive rate that remembers up to
+// maxRecent of the most recently seen items exactly
+func newSeenSet(fpRate float64, maxRecent int) *seenSet {
+	return &seenSet{
+		fpRate:    fpRate,
+		recent:    make(map[string]*list.Element),
+		order:     list.New(),
+		maxRecent: maxRecent,
+	}
+}
+
+// Seen reports whether item has been seen before (possibly
+// falsely, at the false positive rate), and remembers it
+// as having been seen if it hasn't
+func (s *seenSet) Seen(item string) bool {
+	if e, ok := s.recent[item]; ok {
+		s.order.MoveToFront(e)
+		return true
+	}
+
+	h1, h2 := bloomHashes(item)
+	for _, f := range s.filters {
+		if f.Test(h1, h2) {
+			s.remember(item)
+			return true
+		}
+	}
+
+	// start a new filter once the current one is full, twice
+	// the size with half the false positive rate. The rates
+	// sum to at most twice the first, so it starts at half
+	// the overall rate
+	last := len(s.filters) - 1
+	if last == -1 || s.filters[last].count >= s.filters[last].capacity {
+		capacity := uint64(1 << 16)
+		rate := s.fpRate / 2
+		if last != -1 {
+			capacity = s.filters[last].capacity * 2
+			rate = s.fpRate / math.Pow(2, float64(len(s.filters)+1))
+		}
+		s.filters = append(s.filters, newBloomFilter(capacity, rate))
+		last++
+	}
+
+	s.filters[last].Add(h1, h2)
+	s.remember(item)
+	return false
+}
+
+// remember adds item to the recently seen items,
+// forgetting the least recently seen if need be
+func (s *seenSet) remember(item string) {
+	if s.maxRecent <= 0 {
+		return
+	}
+
+	if s.order.Len() >= s.maxRecent {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.recent, oldest.Value.(string))
+	}
+	s.recent[item] = s.order.PushFront(item)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// fullRate returns the false positive rate of a bloom filter
+// once it's full, according to its size and number of hashes
+func fullRate(b *bloomFilter) float64 {
+	k, m, n := float64(b.k), float64(b.m), float64(b.capacity)
+	return math.Pow(1-math.Exp(-k*n/m), k)
+}
+
+func TestBloomFilterFalsePositives(t *testing.T) {
+	cases := []struct {
+		capacity uint64
+		fpRate   float64
+	}{
+		{1000, 0.01},
+		{10000, 0.01},
+		{10000, 0.001},
+		{1 << 16, 0.0001},
+	}
+
+	for _, c := range cases {
+		b := newBloomFilter(c.capacity, c.fpRate)
+		for i := uint64(0); i < c.capacity; i++ {
+			b.Add(bloomHashes(fmt.Sprintf("in-%d", i)))
+		}
+
+		// everything added is found
+		for i := uint64(0); i < c.capacity; i++ {
+			if !b.Test(bloomHashes(fmt.Sprintf("in-%d", i))) {
+				t.Fatalf("capacity %d: in-%d was added but not found", c.capacity, i)
+			}
+		}
+
+		// and when full, things that weren't are only found at
+		// about the false positive rate it was sized for
+		trials := 200000
+		falsePositives := 0
+		for i := 0; i < trials; i++ {
+			if b.Test(bloomHashes(fmt.Sprintf("out-%d", i))) {
+				falsePositives++
+			}
+		}
+
+		if rate := float64(falsePositives) / float64(trials); rate > c.fpRate*1.5 {
+			t.Errorf("capacity %d at rate %g: got false positive rate %g", c.capacity, c.fpRate, rate)
+		}
+	}
+}
+
+func TestSeenSet(t *testing.T) {
+	s := newSeenSet(0.001, 10)
+
+	for _, item := range []string{"a", "b", "c"} {
+		if s.Seen(item) {
+			t.Errorf("Seen(%q) = true the first time", item)
+		}
+		if !s.Seen(item) {
+			t.Errorf("Seen(%q) = false the second time", item)
+		}
+	}
+
+	// items that have dropped out of the recent items
+	// are still remembered by the filters
+	for i := 0; i < 100; i++ {
+		s.Seen(fmt.Sprintf("filler-%d", i))
+	}
+	if _, ok := s.recent["a"]; ok {
+		t.Fatalf("a should have been forgotten from the recent items")
+	}
+	if !s.Seen("a") {
+		t.Errorf("Seen(a) = false after it was forgotten from the recent items")
+	}
+}
+
+func TestSeenSetGrowth(t *testing.T) {
+	const fpRate = 0.01
+	s := newSeenSet(fpRate, 0)
+
+	// fill the first filter and the second (twice the size),
+	// until a third is started. Items that are falsely seen
+	// aren't added, so it takes a few more than that
+	first := 1 << 16
+	n := 0
+	falsePositives := 0
+	for len(s.filters) < 3 && n < 4*first {
+		if s.Seen(fmt.Sprintf("item-%d", n)) {
+			falsePositives++
+		}
+		n++
+	}
+
+	if len(s.filters) != 3 {
+		t.Fatalf("got %d filters after %d items, want 3", len(s.filters), n)
+	}
+	if added := n - falsePositives; added != 3*first+1 {
+		t.Errorf("third filter started after %d items were added, want %d", added, 3*first+1)
+	}
+
+	total := 0.0
+	for i, f := range s.filters {
+		if want := uint64(first) << uint(i); f.capacity != want {
+			t.Errorf("filter %d has capacity %d, want %d", i, f.capacity, want)
+		}
+		total += fullRate(f)
+	}
+
+	// each filter has half the rate of the one before it,
+	// starting at half the overall rate, so together
+	// they can never go over it
+	if total >= fpRate {
+		t.Errorf("filters have a combined false positive rate of %g, over %g", total, fpRate)
+	}
+
+	// new items are falsely seen at about the rates of the
+	// filters they're tested against, which are fuller the
+	// earlier they are
+	if rate := float64(falsePositives) / float64(n); rate > fpRate {
+		t.Errorf("%d of %d new items were falsely seen, a rate of %g", falsePositives, n, rate)
+	}
+
+	for i := 0; i < n; i += 1000 {
+		if !s.Seen(fmt.Sprintf("item-%d", i)) {
+			t.Fatalf("item-%d was forgotten", i)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errNotChunkable is returned by fetchChunked for files that
+// should be downloaded normally: because they're too small,
+// or the server doesn't support range requests
+var errNotChunkable = errors.New("not chunkable")
+
+// fetchChunked downloads a large file by requesting byte ranges
+// of it concurrently and reassembling them. A HEAD request is
+// made first to find the size of the file and check that the
+// server supports range requests. Each range is checked to be
+// exactly what was asked for, and requested with If-Range so
+// that the file changing part way through can't result in a
+// file made of parts of different versions
+func fetchChunked(ctx context.Context, args []string, chunks int, minSize int64, maxSize int) (response, error) {
+	// servers that can't answer HEAD requests (or that -f
+	// makes errors of) may well still answer the download
+	head, err := fetch(ctx, append(append([]string{}, args...), "--head"))
+	if err != nil {
+		return response{}, errNotChunkable
+	}
+
+	size, err := strconv.ParseInt(head.headers.Get("Content-Length"), 10, 64)
+	if err != nil || size < minSize || head.status != http.StatusOK {
+		return response{}, errNotChunkable
+	}
+
+	// files over the maximum size are downloaded normally
+	// so that they're cut short in the usual way
+	if maxSize > 0 && size > int64(maxSize) {
+		return response{}, errNotChunkable
+	}
+	if !strings.EqualFold(head.headers.Get("Accept-Ranges"), "bytes") {
+		return response{}, errNotChunkable
+	}
+
+	// a strong validator is needed to be sure that all
+	// of the ranges are from the same version of the file
+	validator := head.headers.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = head.headers.Get("Last-Modified")
+	}
+
+	// there has to be at least a byte for each range
+	if size < int64(chunks) {
+		return response{}, errNotChunkable
+	}
+	chunkSize := size / int64(chunks)
+
+	// the ranges are written straight to their place in a
+	// temporary file rather than each being held in memory
+	// until they can be joined up
+	f, err := ioutil.TempFile("", "concurl-chunks-")
+	if err != nil {
+		return response{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	errs := make([]error, chunks)
+
+	var wg sync.WaitGroup
+	for i := 0; i < chunks; i++ {
+		start := int64(i) * chunkSize
+		end := start + chunkSize - 1
+		if i == chunks-1 {
+			end = size - 1
+		}
+
+		wg.Add(1)
+		go func(i int, start, end int64) {
+			defer wg.Done()
+			errs[i] = fetchRange(ctx, args, f.Name(), start, end, size, validator)
+		}(i, start, end)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return response{}, fmt.Errorf("chunk %d: %w", i+1, err)
+		}
+	}
+
+	out := make([]byte, size)
+	if _, err := f.ReadAt(out, 0); err != nil {
+		return response{}, err
+	}
+
+	return response{
+		out:     out,
+		status:  http.StatusOK,
+		headers: head.headers,
+		chunks:  chunks,
+	}, nil
+}
+
+// fetchRange requests a single byte range of a file of the
+// provided size, writing it to its place in the file called
+// name, and checks that it got exactly that range
+func fetchRange(ctx context.Context, args []string, name string, start, end, size int64, validator string) error {
+	rangeArgs := append([]string{}, args...)
+	rangeArgs = append(rangeArgs, "--range", fmt.Sprintf("%d-%d", start, end))
+	if validator != "" {
+		rangeArgs = append(rangeArgs, "-H", "If-Range: "+validator)
+	}
+
+	out, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := out.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := fetchTo(ctx, rangeArgs, captureLimits{}, nil, out)
+	if err != nil {
+		return err
+	}
+
+	// anything but a partial response means the server
+	// ignored the range, probably because the file changed
+	if resp.status != http.StatusPartialContent {
+		return fmt.Errorf("got status %d instead of 206", resp.status)
+	}
+
+	want := fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+	if got := resp.headers.Get("Content-Range"); got != want {
+		return fmt.Errorf("got range %q instead of %q", got, want)
+	}
+
+	// curl shares the file's offset, so where it's
+	// got to says how much curl wrote
+	pos, err := out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if pos-start != end-start+1 {
+		return fmt.Errorf("got %d bytes instead of %d", pos-start, end-start+1)
+	}
+
+	return nil
+}
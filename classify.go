@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a classifier sends summaries of responses to an external
+// command and reads back its verdict on each of them. The
+// protocol is newline delimited JSON in both directions: one
+// classifyRequest per line on the command's stdin, answered
+// by one verdict per line on its stdout with the ID of the
+// request it answers. Verdicts can be written in any order,
+// so the command can work on several responses at once
+type classifier struct {
+	sync.Mutex
+	cmd       *exec.Cmd
+	in        io.WriteCloser
+	bodyLimit int
+	timeout   time.Duration
+	nextID    int
+	err       error
+
+	// where to send the verdict for each request that's
+	// waiting for one, and a channel that's closed once
+	// verdicts stop being read
+	pending map[int]chan verdict
+	done    chan struct{}
+}
+
+// a classifyRequest is the summary of a response that is
+// sent to the classifier command
+type classifyRequest struct {
+	ID      int                 `json:"id"`
+	URL     string              `json:"url"`
+	Status  int                 `json:"status"`
+	Headers map[string][]string `json:"headers"`
+	Size    int                 `json:"size"`
+	Title   string              `json:"title"`
+	Body    string              `json:"body"`
+}
+
+// a verdict is the classifier command's answer for a response;
+// ID is the ID of the classifyRequest it answers
+type verdict struct {
+	ID     int                `json:"id"`
+	Labels []string           `json:"labels"`
+	Scores map[string]float64 `json:"scores"`
+}
+
+// newClassifier starts command (split on whitespace into the
+// command and its arguments) as a classifier. At most the
+// first bodyLimit bytes of each body are sent to it, and it's
+// given timeout to answer each one. The command is killed if
+// ctx is cancelled
+func newClassifier(ctx context.Context, command string, bodyLimit int, timeout time.Duration) (*classifier, error) {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return nil, errors.New("empty classifier command")
+	}
+
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.Stderr = os.Stderr
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := &classifier{
+		cmd:       cmd,
+		in:        in,
+		bodyLimit: bodyLimit,
+		timeout:   timeout,
+		pending:   make(map[int]chan verdict),
+		done:      make(chan struct{}),
+	}
+
+	go c.read(out)
+
+	return c, nil
+}
+
+// read reads verdicts from r and passes each of them on to the
+// request waiting for it, until r ends or a verdict can't be
+// understood, at which point the classifier fails
+func (c *classifier) read(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == nil {
+			err = c.answer(line)
+		}
+		if err != nil {
+			c.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.Unlock()
+			close(c.done)
+
+			// keep reading so the command isn't left
+			// stuck writing to a full pipe
+			io.Copy(ioutil.Discard, br)
+			return
+		}
+	}
+}
+
+// answer passes on a line with a verdict to the request it
+// answers. Verdicts for requests that were given up on are
+// skipped, but those for requests never sent are an error
+func (c *classifier) answer(line []byte) error {
+	var v verdict
+	if err := json.Unmarshal(line, &v); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if v.ID <= 0 || v.ID > c.nextID {
+		return fmt.Errorf("classifier answered request %d, which wasn't sent", v.ID)
+	}
+
+	if ch, ok := c.pending[v.ID]; ok {
+		delete(c.pending, v.ID)
+		ch <- v
+	}
+	return nil
+}
+
+// Classify sends a summary of resp to the classifier command
+// and waits for its verdict, or until ctx is cancelled or the
+// timeout is up. Verdicts that turn up after they've been given
+// up on are skipped. Once the command has failed to answer
+// properly, every call returns the same error
+func (c *classifier) Classify(ctx context.Context, u, title string, resp response) (verdict, error) {
+	body := resp.out
+	if len(body) > c.bodyLimit {
+		body = body[:c.bodyLimit]
+	}
+
+	req := classifyRequest{
+		URL:     u,
+		Status:  resp.status,
+		Headers: resp.headers,
+		Size:    len(resp.out),
+		Title:   title,
+		Body:    string(body),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// the lock is only held to send the request, so other
+	// requests can be sent while this one is worked on
+	c.Lock()
+
+	if c.err != nil {
+		c.Unlock()
+		return verdict{}, c.err
+	}
+
+	c.nextID++
+	req.ID = c.nextID
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		c.Unlock()
+		return verdict{}, err
+	}
+
+	answer := make(chan verdict, 1)
+	c.pending[req.ID] = answer
+
+	// a write that's given up on can't be taken back, so
+	// nothing more can be sent once one has been
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.in.Write(append(line, '\n'))
+		written <- err
+	}()
+
+	select {
+	case err = <-written:
+	case <-ctx.Done():
+		err = fmt.Errorf("sending to classifier: %s", ctx.Err())
+	}
+	if err != nil {
+		c.err = err
+		delete(c.pending, req.ID)
+		c.Unlock()
+		return verdict{}, err
+	}
+
+	c.Unlock()
+
+	select {
+	case v := <-answer:
+		return v, nil
+	case <-c.done:
+		// the verdict may have come just before the end
+		select {
+		case v := <-answer:
+			return v, nil
+		default:
+		}
+		c.Lock()
+		defer c.Unlock()
+		return verdict{}, c.err
+	case <-ctx.Done():
+		// a late answer can be told apart by its ID,
+		// so the classifier can still be used
+		c.Lock()
+		delete(c.pending, req.ID)
+		c.Unlock()
+		return verdict{}, fmt.Errorf("waiting for verdict: %s", ctx.Err())
+	}
+}
+
+// Close closes the classifier command's stdin and
+// waits for it to exit
+func (c *classifier) Close() error {
+	c.in.Close()
+	return c.cmd.Wait()
+}
+
+// record writes the verdict to w in the format used for the
+// details at the top of output files; labels are recorded
+// as tags so they show up in reports
+func (v verdict) record(w io.Writer) {
+	for _, label := range v.Labels {
+		fmt.Fprintf(w, "tag: %s\n", label)
+	}
+
+	names := make([]string, 0, len(v.Scores))
+	for name := range v.Scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "score: %s %g\n", name, v.Scores[name])
+	}
+}
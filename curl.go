@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a response is the output of a single curl invocation
+// along with the status and headers of the final
+// response (i.e. after any redirects were followed)
+type response struct {
+	out     []byte
+	status  int
+	headers http.Header
+
+	// the number of byte ranges the output was downloaded
+	// in concurrently, or 0 if it was downloaded normally
+	chunks int
+
+	// why the output was cut short, if it was
+	capture string
+}
+
+// fetch runs curl with the provided arguments, having it
+// dump the response headers to a pipe so that they can
+// be inspected without altering the output or being
+// written to disk. curl is killed if ctx is cancelled
+// before it finishes
+func fetch(ctx context.Context, args []string) (response, error) {
+	return fetchLimited(ctx, args, captureLimits{}, nil)
+}
+
+// captureLimits limit how much of a response is captured,
+// so that responses that never end (e.g. event streams)
+// don't hold on to a worker forever
+type captureLimits struct {
+	maxSize     int
+	maxDuration time.Duration
+}
+
+// fetchLimited is like fetch, but stops curl once the output
+// has reached the maximum size or curl has been running for
+// the maximum duration. Stopping early isn't an error: the
+// output captured so far is returned, and the response's
+// capture field says why it was cut short. If trace isn't
+// nil, curl's trace of the request is written to it
+func fetchLimited(ctx context.Context, args []string, limits captureLimits, trace *traceWriter) (response, error) {
+	return fetchTo(ctx, args, limits, trace, nil)
+}
+
+// fetchTo is like fetchLimited, but if out isn't nil curl
+// writes the output straight to it (from its current offset)
+// instead of it being returned, and there's no maximum size
+func fetchTo(ctx context.Context, args []string, limits captureLimits, trace *traceWriter, out *os.File) (response, error) {
+	// the header dump and trace are passed to curl as extra
+	// file descriptors, starting at 3 after stdin, stdout
+	// and stderr
+	var extra []*os.File
+	var readers []*os.File
+	pipe := func() (string, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return "", err
+		}
+		readers = append(readers, r)
+		extra = append(extra, w)
+		return fmt.Sprintf("/dev/fd/%d", 2+len(extra)), nil
+	}
+	defer func() {
+		for _, f := range append(readers, extra...) {
+			f.Close()
+		}
+	}()
+
+	headerFile, err := pipe()
+	if err != nil {
+		return response{}, err
+	}
+	args = append(append([]string{}, args...), "--dump-header", headerFile)
+
+	if trace != nil {
+		traceFile, err := pipe()
+		if err != nil {
+			return response{}, err
+		}
+		args = append(args, "--trace", traceFile)
+	}
+
+	if out != nil {
+		limits.maxSize = 0
+	}
+
+	// curl buffers its output, which would be lost
+	// if it has to be stopped early
+	limited := limits.maxSize > 0 || limits.maxDuration > 0
+	if limited {
+		args = append(args, "--no-buffer")
+	}
+
+	cmd := exec.CommandContext(ctx, "curl", args...)
+	cmd.ExtraFiles = extra
+
+	// anything curl says on stderr would spoil output
+	// that's written straight to a file
+	var pr, pw *os.File
+	if out != nil {
+		cmd.Stdout = out
+	} else {
+		pr, pw, err = os.Pipe()
+		if err != nil {
+			return response{}, err
+		}
+		cmd.Stdout = pw
+		cmd.Stderr = pw
+	}
+
+	err = cmd.Start()
+	if pw != nil {
+		pw.Close()
+	}
+	for _, f := range extra {
+		f.Close()
+	}
+	extra = nil
+	if err != nil {
+		if pr != nil {
+			pr.Close()
+		}
+		return response{}, err
+	}
+
+	// the pipes have to be read while curl is running
+	// so that it doesn't block writing to them
+	raw := &bytes.Buffer{}
+	var wg sync.WaitGroup
+	wg.Add(len(readers))
+	go func() {
+		defer wg.Done()
+		io.Copy(raw, readers[0])
+	}()
+	if trace != nil {
+		go func() {
+			defer wg.Done()
+			io.Copy(trace, readers[1])
+		}()
+	}
+
+	var timedOut int32
+	if limits.maxDuration > 0 {
+		timer := time.AfterFunc(limits.maxDuration, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+
+	var output []byte
+	capture := ""
+	if pr != nil {
+		var r io.Reader = pr
+		if limits.maxSize > 0 {
+			r = io.LimitReader(pr, int64(limits.maxSize)+1)
+		}
+
+		output, _ = ioutil.ReadAll(r)
+
+		if limits.maxSize > 0 && len(output) > limits.maxSize {
+			output = output[:limits.maxSize]
+			capture = "max-size"
+			cmd.Process.Kill()
+		}
+		pr.Close()
+	}
+
+	err = cmd.Wait()
+	wg.Wait()
+	if err != nil && capture == "" && atomic.LoadInt32(&timedOut) == 1 {
+		capture = "max-stream-duration"
+	}
+
+	resp := response{out: output, headers: http.Header{}}
+	if err != nil && capture == "" {
+		return resp, newCurlError(ctx, err)
+	}
+
+	resp.status, resp.headers = parseHeaders(raw.Bytes())
+
+	// a response that was cut short even though it said how
+	// long it was is just big; one that didn't say is most
+	// likely a stream that was never going to end
+	if capture != "" {
+		kind := "truncated"
+		if resp.headers.Get("Content-Length") == "" {
+			kind = "streaming"
+		}
+		resp.capture = kind + " (" + capture + ")"
+	}
+
+	return resp, nil
+}
+
+// a curlError is a failed run of curl, classified by
+// the kind of problem its exit status says it had
+type curlError struct {
+	class  string
+	status int
+}
+
+// curlErrorClasses are the classes of curl's exit statuses;
+// anything not listed is in the "curl" class
+var curlErrorClasses = map[int]string{
+	5:  "dns",
+	6:  "dns",
+	7:  "connect",
+	55: "connect",
+	56: "connect",
+	28: "timeout",
+	35: "tls",
+	51: "tls",
+	53: "tls",
+	54: "tls",
+	58: "tls",
+	59: "tls",
+	60: "tls",
+	64: "tls",
+	66: "tls",
+	77: "tls",
+	80: "tls",
+	82: "tls",
+	83: "tls",
+	90: "tls",
+	91: "tls",
+	8:  "http",
+	16: "http",
+	18: "http",
+	22: "http",
+	47: "http",
+	52: "http",
+	92: "http",
+	95: "http",
+}
+
+// newCurlError returns a *curlError for the error from running
+// curl, or err itself if curl didn't run to completion. Runs
+// killed because ctx was cancelled are in the "interrupted" class
+func newCurlError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return &curlError{class: "interrupted", status: -1}
+	}
+
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		return err
+	}
+
+	class := curlErrorClasses[exit.ExitCode()]
+	if class == "" {
+		class = "curl"
+	}
+	return &curlError{class: class, status: exit.ExitCode()}
+}
+
+func (e *curlError) Error() string {
+	if e.status == -1 {
+		return e.class
+	}
+	return fmt.Sprintf("%s: curl exit status %d", e.class, e.status)
+}
+
+// parseHeaders returns the status code and headers of the
+// last response in a curl header dump. There can be several
+// responses in a dump when redirects are followed, or when
+// a proxy or server sends informational responses first
+func parseHeaders(raw []byte) (int, http.Header) {
+	// find the start of the last response
+	start := bytes.LastIndex(raw, []byte("\nHTTP/"))
+	if start == -1 {
+		start = 0
+	} else {
+		start++
+	}
+
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw[start:])))
+
+	line, err := r.ReadLine()
+	if err != nil {
+		return 0, http.Header{}
+	}
+
+	status := 0
+	parts := strings.Fields(line)
+	if len(parts) > 1 {
+		status, _ = strconv.Atoi(parts[1])
+	}
+
+	// a malformed header shouldn't cost us the
+	// headers that were parsed before it
+	h, _ := r.ReadMIMEHeader()
+	if h == nil {
+		h = textproto.MIMEHeader{}
+	}
+
+	return status, http.Header(h)
+}
+
+// acceptProfiles are the Accept headers for -accept-profile;
+// many servers send different (and often more useful)
+// representations of a resource depending on Accept
+var acceptProfiles = map[string]string{
+	"api":     "application/json, application/problem+json;q=0.9, application/xml;q=0.8, */*;q=0.5",
+	"browser": "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+	"feed":    "application/feed+json, application/atom+xml, application/rss+xml, application/xml;q=0.9, text/xml;q=0.8, */*;q=0.5",
+}
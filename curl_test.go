@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	cases := []struct {
+		in      string
+		status  int
+		headers http.Header
+	}{
+		{"", 0, http.Header{}},
+		{
+			"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 12\r\n\r\n",
+			200,
+			http.Header{"Content-Type": {"text/html"}, "Content-Length": {"12"}},
+		},
+		{
+			"HTTP/2 200\r\ncontent-type: application/json\r\nset-cookie: a=1\r\nset-cookie: b=2\r\n\r\n",
+			200,
+			http.Header{"Content-Type": {"application/json"}, "Set-Cookie": {"a=1", "b=2"}},
+		},
+
+		// with -L, the headers of every response in the chain
+		// of redirects are dumped; the last one is the one
+		// the output is for
+		{
+			"HTTP/1.1 301 Moved Permanently\r\nLocation: /b\r\nContent-Length: 0\r\n\r\n" +
+				"HTTP/1.1 302 Found\r\nLocation: /c\r\n\r\n" +
+				"HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n",
+			200,
+			http.Header{"Content-Type": {"text/plain"}},
+		},
+
+		// interim responses come before the final one
+		{
+			"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 201 Created\r\nLocation: /items/1\r\n\r\n",
+			201,
+			http.Header{"Location": {"/items/1"}},
+		},
+		{
+			"HTTP/1.1 200 Connection established\r\n\r\nHTTP/2 404\r\ncontent-length: 9\r\n\r\n",
+			404,
+			http.Header{"Content-Length": {"9"}},
+		},
+
+		// a malformed header doesn't lose the ones before it
+		{
+			"HTTP/1.1 200 OK\r\nServer: test\r\nbroken header\r\nX-After: 1\r\n\r\n",
+			200,
+			http.Header{"Server": {"test"}},
+		},
+		{"garbage", 0, http.Header{}},
+	}
+
+	for _, c := range cases {
+		status, headers := parseHeaders([]byte(c.in))
+		if status != c.status || !reflect.DeepEqual(headers, c.headers) {
+			t.Errorf("parseHeaders(%q) = %d, %v, want %d, %v", c.in, status, headers, c.status, c.headers)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dispositionFilename returns the filename from an attachment
+// Content-Disposition header, or an empty string if there
+// isn't one
+func dispositionFilename(h http.Header) string {
+	disposition, params, err := mime.ParseMediaType(h.Get("Content-Disposition"))
+	if err != nil || disposition != "attachment" {
+		return ""
+	}
+	return params["filename"]
+}
+
+// sanitizeFilename makes a filename provided by a server
+// safe to use in the output directory; replacing anything
+// other than letters, digits, dots, dashes and underscores
+// and making sure it can't be used to traverse directories
+// or create hidden files
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+
+	name = strings.TrimLeft(name, ".")
+
+	if len(name) > 200 {
+		ext := filepath.Ext(name)
+		if len(ext) > 20 {
+			ext = ""
+		}
+		name = name[:200-len(ext)] + ext
+	}
+
+	return name
+}
+
+// markerName returns the name of the file that records that the
+// output for a request was saved under a suggested filename
+func markerName(filename string) string {
+	return "." + filename
+}
+
+// writeUnique writes data to a new file for name in dir,
+// adding a numeric suffix before the extension if a file with
+// that name already exists (e.g. report.pdf, report-1.pdf, ...)
+// and returns the path of the file that was written. The data
+// is written to a temporary file first and then hard linked to
+// the name, which fails if the name has been taken, so that
+// a partially written file is never visible under it
+func writeUnique(dir, name string, data []byte) (string, error) {
+	tmp, err := writeTemp(dir, name, data)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		p := filepath.Join(dir, candidate)
+
+		err := os.Link(tmp, p)
+		if os.IsExist(err) {
+			continue
+		}
+		return p, err
+	}
+}
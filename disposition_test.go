@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDispositionFilename(t *testing.T) {
+	cases := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"inline; filename=\"report.pdf\"", ""},
+		{"attachment", ""},
+		{"attachment; filename=\"report.pdf\"", "report.pdf"},
+		{"attachment; filename=report.pdf", "report.pdf"},
+		{"ATTACHMENT; filename=\"report.pdf\"", "report.pdf"},
+		{"attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf", "résumé.pdf"},
+		{"attachment; filename=\"fallback.pdf\"; filename*=UTF-8''real.pdf", "real.pdf"},
+		{"attachment; filename*=UTF-8''..%2F..%2Fetc%2Fpasswd", "../../etc/passwd"},
+		{"attachment; filename=\"unterminated", ""},
+	}
+
+	for _, c := range cases {
+		h := http.Header{}
+		if c.header != "" {
+			h.Set("Content-Disposition", c.header)
+		}
+		if got := dispositionFilename(h); got != c.want {
+			t.Errorf("dispositionFilename(%q) = %q, want %q", c.header, got, c.want)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	long := strings.Repeat("a", 300)
+
+	cases := []struct {
+		in, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"annual report.pdf", "annual_report.pdf"},
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{"../../etc/passwd", "passwd"},
+		{"..\\..\\windows\\win.ini", "win.ini"},
+		{"/etc/passwd", "passwd"},
+		{"a/", "a"},
+		{"a/.", ""},
+		{"a/..", ""},
+		{".htaccess", "htaccess"},
+		{"...hidden", "hidden"},
+		{"a%2F..%2Fb", "a_2F.._2Fb"},
+		{"résumé.pdf", "r_sum_.pdf"},
+		{"nul\x00byte.txt", "nul_byte.txt"},
+		{long + ".pdf", long[:196] + ".pdf"},
+		{long, long[:200]},
+		{"a." + long, ("a." + long)[:200]},
+	}
+
+	for _, c := range cases {
+		if got := sanitizeFilename(c.in); got != c.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestWriteUnique(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, want := range []string{"report.pdf", "report-1.pdf", "report-2.pdf"} {
+		p, err := writeUnique(dir, "report.pdf", []byte(want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != filepath.Join(dir, want) {
+			t.Errorf("writeUnique wrote %s, want %s", p, want)
+		}
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s contains %q, want %q", want, data, want)
+		}
+	}
+
+	// the temporary files shouldn't be left behind
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("got %d files, want 3", len(entries))
+	}
+}
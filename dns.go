@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// a dnsAnswer is the result of resolving a hostname
+type dnsAnswer struct {
+	cnames []string
+	addrs  []net.IPAddr
+	err    error
+}
+
+// a dnsCache resolves each hostname once per run, so that
+// every request for a host records (and uses) the same answer
+// and DNS isn't asked again for every URL
+type dnsCache struct {
+	sync.Mutex
+	hosts map[string]*dnsLookup
+}
+
+// a dnsLookup is the answer for a single hostname
+type dnsLookup struct {
+	once   sync.Once
+	answer dnsAnswer
+}
+
+// newDNSCache returns a new *dnsCache. The dig command line
+// utility must be installed, as it's used to get the whole
+// CNAME chain for each hostname
+func newDNSCache() (*dnsCache, error) {
+	if _, err := exec.LookPath("dig"); err != nil {
+		return nil, err
+	}
+	return &dnsCache{hosts: make(map[string]*dnsLookup)}, nil
+}
+
+// Resolve returns the answer for host, resolving it the first
+// time it's called for that host. Later calls wait for the
+// first to finish and return the same answer
+func (c *dnsCache) Resolve(ctx context.Context, host string) dnsAnswer {
+	host = strings.ToLower(host)
+
+	c.Lock()
+	l, ok := c.hosts[host]
+	if !ok {
+		l = &dnsLookup{}
+		c.hosts[host] = l
+	}
+	c.Unlock()
+
+	l.once.Do(func() {
+		l.answer = resolve(ctx, host)
+	})
+	return l.answer
+}
+
+// resolve looks up the CNAME chain and the IPv4 and IPv6
+// addresses for host using dig. Hosts that DNS doesn't have
+// any addresses for (e.g. ones in /etc/hosts) are looked up
+// the usual way instead, without a chain
+func resolve(ctx context.Context, host string) dnsAnswer {
+	var a dnsAnswer
+
+	// dig would take a host starting with - for an option
+	if !strings.HasPrefix(host, "-") {
+		cmd := exec.CommandContext(ctx, "dig", "+noall", "+answer", host, "A", host, "AAAA")
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			a.err = fmt.Errorf("dig failed: %s: %s", err, bytes.TrimSpace(stderr.Bytes()))
+			return a
+		}
+		a.cnames, a.addrs = parseDig(out)
+	}
+
+	if len(a.addrs) == 0 {
+		a.cnames = nil
+		a.addrs, a.err = net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+
+	return a
+}
+
+// parseDig returns the names in the CNAME chain, in order, and
+// the addresses in dig's answer section for A and AAAA queries
+// (with +noall +answer), which have lines like:
+//
+//	www.example.com.	300	IN	CNAME	edge.example.net.
+//	edge.example.net.	20	IN	A	203.0.113.7
+//
+// The chain is included for both queries, so names and
+// addresses are only returned once
+func parseDig(out []byte) ([]string, []net.IPAddr) {
+	var cnames []string
+	var addrs []net.IPAddr
+	seen := make(map[string]bool)
+
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || strings.HasPrefix(fields[0], ";") || fields[2] != "IN" {
+			continue
+		}
+
+		kind, value := fields[3], fields[4]
+		if seen[kind+" "+value] {
+			continue
+		}
+		seen[kind+" "+value] = true
+
+		switch kind {
+		case "CNAME":
+			cnames = append(cnames, value)
+		case "A", "AAAA":
+			if ip := net.ParseIP(value); ip != nil {
+				addrs = append(addrs, net.IPAddr{IP: ip})
+			}
+		}
+	}
+
+	return cnames, addrs
+}
+
+// resolveArgs returns the arguments that make curl use the
+// addresses in the answer for host and port rather than
+// resolving it again, so that the answer recorded is the
+// one that was actually used for the request
+func (a dnsAnswer) resolveArgs(host, port string) []string {
+	if a.err != nil || len(a.addrs) == 0 {
+		return nil
+	}
+
+	addrs := make([]string, len(a.addrs))
+	for i, addr := range a.addrs {
+		addrs[i] = addr.IP.String()
+		if addr.IP.To4() == nil {
+			addrs[i] = "[" + addrs[i] + "]"
+		}
+	}
+
+	return []string{"--resolve", host + ":" + port + ":" + strings.Join(addrs, ",")}
+}
+
+// record writes the answer to w in the format used
+// for the details at the top of output files
+func (a dnsAnswer) record(w io.Writer) {
+	if a.err != nil {
+		fmt.Fprintf(w, "dns-error: %s\n", a.err)
+		return
+	}
+
+	for _, cname := range a.cnames {
+		fmt.Fprintf(w, "dns-cname: %s\n", cname)
+	}
+
+	for _, addr := range a.addrs {
+		if addr.IP.To4() != nil {
+			fmt.Fprintf(w, "dns-a: %s\n", addr.IP)
+		} else {
+			fmt.Fprintf(w, "dns-aaaa: %s\n", addr.IP)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseDig(t *testing.T) {
+	cases := []struct {
+		in     string
+		cnames []string
+		addrs  []string
+	}{
+		{"", nil, nil},
+		{"example.com.\t300\tIN\tA\t93.184.216.34\n", nil, []string{"93.184.216.34"}},
+		{
+			"www.example.com.\t300\tIN\tCNAME\tedge.example.net.\n" +
+				"edge.example.net.\t60\tIN\tCNAME\ta1.cdn.example.\n" +
+				"a1.cdn.example.\t20\tIN\tA\t203.0.113.7\n" +
+				"a1.cdn.example.\t20\tIN\tA\t203.0.113.8\n" +
+				"www.example.com.\t300\tIN\tCNAME\tedge.example.net.\n" +
+				"edge.example.net.\t60\tIN\tCNAME\ta1.cdn.example.\n" +
+				"a1.cdn.example.\t20\tIN\tAAAA\t2001:db8::7\n",
+			[]string{"edge.example.net.", "a1.cdn.example."},
+			[]string{"203.0.113.7", "203.0.113.8", "2001:db8::7"},
+		},
+		{"; <<>> DiG 9.18 <<>> example.com\n;; connection timed out; no servers could be reached\n", nil, nil},
+		{"example.com. 300 IN A not-an-ip\nexample.com. 300 IN TXT \"v=spf1\"\n", nil, nil},
+		{"example.com.\t300\tCH\tA\t192.0.2.1\n", nil, nil},
+	}
+
+	for _, c := range cases {
+		cnames, addrs := parseDig([]byte(c.in))
+
+		var got []string
+		for _, a := range addrs {
+			got = append(got, a.IP.String())
+		}
+		if !reflect.DeepEqual(cnames, c.cnames) || !reflect.DeepEqual(got, c.addrs) {
+			t.Errorf("parseDig(%q) = %q, %q, want %q, %q", c.in, cnames, got, c.cnames, c.addrs)
+		}
+	}
+}
+
+func TestResolveArgs(t *testing.T) {
+	a := dnsAnswer{addrs: []net.IPAddr{{IP: net.ParseIP("203.0.113.7")}, {IP: net.ParseIP("2001:db8::7")}}}
+
+	want := []string{"--resolve", "example.com:443:203.0.113.7,[2001:db8::7]"}
+	if got := a.resolveArgs("example.com", "443"); !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveArgs = %q, want %q", got, want)
+	}
+
+	if got := (dnsAnswer{}).resolveArgs("example.com", "443"); got != nil {
+		t.Errorf("resolveArgs for an empty answer = %q, want nil", got)
+	}
+}
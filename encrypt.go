@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// an encrypter encrypts output files before they are stored
+// using the age command line utility, so that only holders
+// of one of the recipients' identities can read them
+type encrypter struct {
+	recipients string
+}
+
+// newEncrypter returns an *encrypter for a spec like
+// age:recipients.txt, where recipients.txt is a file
+// of age recipients (or SSH public keys), one per line
+func newEncrypter(spec string) (*encrypter, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[0] != "age" || parts[1] == "" {
+		return nil, fmt.Errorf("unsupported encryption %q (want age:recipients.txt)", spec)
+	}
+
+	if _, err := os.Stat(parts[1]); err != nil {
+		return nil, err
+	}
+
+	if _, err := exec.LookPath("age"); err != nil {
+		return nil, err
+	}
+
+	return &encrypter{recipients: parts[1]}, nil
+}
+
+// Encrypt returns data encrypted for the recipients
+func (e *encrypter) Encrypt(data []byte) ([]byte, error) {
+	cmd := exec.Command("age", "--encrypt", "--recipients-file", e.recipients)
+	cmd.Stdin = bytes.NewReader(data)
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("age failed: %s: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// simhash returns a 64 bit locality sensitive hash of data,
+// meaning that similar inputs produce hashes that differ
+// in only a small number of bits. The features hashed are
+// overlapping runs of three words so that small changes
+// (e.g. a reflected parameter) only affect a few of them
+func simhash(data []byte) uint64 {
+	return simhashWords(splitWords(data))
+}
+
+// simhashWords is simhash for data that has already
+// been split into words
+func simhashWords(words [][]byte) uint64 {
+	const shingle = 3
+	var weights [64]int
+
+	for i := 0; i < len(words); i++ {
+		end := i + shingle
+		if end > len(words) {
+			// short inputs still get a hash
+			if i > 0 {
+				break
+			}
+			end = len(words)
+		}
+
+		h := fnv.New64a()
+		for _, w := range words[i:end] {
+			h.Write(w)
+			h.Write([]byte{0})
+		}
+		sum := h.Sum64()
+
+		for b := 0; b < 64; b++ {
+			if sum&(1<<uint(b)) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+
+	var out uint64
+	for b, w := range weights {
+		if w > 0 {
+			out |= 1 << uint(b)
+		}
+	}
+	return out
+}
+
+// similarity returns how similar the inputs for two simhashes
+// were as a number between 0 (nothing alike) and 1 (the same)
+func similarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+// splitWords splits data into runs of letters and digits
+func splitWords(data []byte) [][]byte {
+	var words [][]byte
+	start := -1
+
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+
+		if isWord && start == -1 {
+			start = i
+		}
+		if !isWord && start != -1 {
+			words = append(words, data[start:i])
+			start = -1
+		}
+		i += size
+	}
+
+	if start != -1 {
+		words = append(words, data[start:])
+	}
+	return words
+}
+
+// a similarityIndex remembers the simhashes of responses
+// on a per-key basis so that near-duplicates of responses
+// that have already been seen can be detected. Only the
+// most recent responses for each key are remembered, so
+// that checking stays cheap on big inputs
+type similarityIndex struct {
+	sync.Mutex
+	threshold float64
+	seen      map[string]*similarEntries
+}
+
+type similarEntry struct {
+	hash uint64
+	url  string
+}
+
+// similarEntries is a ring of the most recent entries for a key
+type similarEntries struct {
+	entries []similarEntry
+	next    int
+}
+
+const (
+	// maxSimilarEntries is the number of responses
+	// remembered for each key
+	maxSimilarEntries = 1000
+
+	// minSimilarWords is the number of words a response
+	// needs before it can be a near-duplicate; the hashes
+	// of shorter ones (e.g. empty bodies) are all alike
+	minSimilarWords = 10
+)
+
+// newSimilarityIndex returns a new *similarityIndex that
+// considers responses to be near-duplicates when they are
+// more similar than the provided threshold
+func newSimilarityIndex(threshold float64) *similarityIndex {
+	return &similarityIndex{
+		threshold: threshold,
+		seen:      make(map[string]*similarEntries),
+	}
+}
+
+// Check returns the URL of a previously seen response for the
+// same host and status that data is a near-duplicate of. If
+// there isn't one, data is remembered as having been seen for
+// u and an empty string is returned. Responses with too little
+// in them to compare are never near-duplicates
+func (s *similarityIndex) Check(host string, status int, u string, data []byte) string {
+	words := splitWords(data)
+	if len(words) < minSimilarWords {
+		return ""
+	}
+	h := simhashWords(words)
+	key := fmt.Sprintf("%s %d", host, status)
+
+	s.Lock()
+	defer s.Unlock()
+
+	seen := s.seen[key]
+	if seen == nil {
+		seen = &similarEntries{}
+		s.seen[key] = seen
+	}
+
+	for _, e := range seen.entries {
+		if similarity(h, e.hash) > s.threshold {
+			return e.url
+		}
+	}
+
+	if len(seen.entries) < maxSimilarEntries {
+		seen.entries = append(seen.entries, similarEntry{h, u})
+		return ""
+	}
+	seen.entries[seen.next] = similarEntry{h, u}
+	seen.next = (seen.next + 1) % maxSimilarEntries
+	return ""
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// versionHeaders are headers whose values identify software
+// and versions, which ought to be the same across an estate
+var versionHeaders = []string{
+	"Server",
+	"Via",
+	"X-Aspnet-Version",
+	"X-Aspnetmvc-Version",
+	"X-Generator",
+	"X-Powered-By",
+	"X-Runtime",
+}
+
+// runHeaders implements the headers subcommand, which pivots
+// the response headers recorded with -save-headers across all
+// of the hosts in an output directory to find the hosts that
+// are configured differently to their siblings
+func runHeaders(args []string) error {
+	fs := flag.NewFlagSet("headers", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: concurl headers [-min-share 0.5] <outdir>\n")
+		fs.PrintDefaults()
+	}
+
+	var minShare float64
+	fs.Float64Var(&minShare, "min-share", 0.5, "Report hosts missing headers sent by at least this share (0-1) of hosts")
+
+	// allow the flags to come after the output dir too
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+
+	files, encrypted, err := readOutputDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// merge the headers from all of the responses for
+	// each host, ignoring files without any headers
+	hosts := make(map[string]http.Header)
+	for _, f := range files {
+		h := f.Headers()
+		if len(h) == 0 {
+			continue
+		}
+
+		if hosts[f.domain] == nil {
+			hosts[f.domain] = http.Header{}
+		}
+		for name, values := range h {
+			for _, v := range values {
+				if !contains(hosts[f.domain][name], v) {
+					hosts[f.domain].Add(name, v)
+				}
+			}
+		}
+	}
+
+	// encrypted output files can't be read, which is a
+	// more likely reason for there being no headers
+	if len(hosts) == 0 && encrypted > 0 {
+		return fmt.Errorf("no headers found in %s; %d encrypted output files were not included", dir, encrypted)
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("no headers found in %s (were they saved with -save-headers?)", dir)
+	}
+
+	fmt.Printf("%d hosts with headers\n", len(hosts))
+	if encrypted > 0 {
+		fmt.Printf("%d encrypted output files were not included\n", encrypted)
+	}
+
+	missingHeaders(hosts, minShare)
+	inconsistentHeaders(hosts)
+
+	return nil
+}
+
+// missingHeaders prints the headers that most hosts send,
+// and the hosts that don't send them
+func missingHeaders(hosts map[string]http.Header, minShare float64) {
+	senders := make(map[string][]string)
+	for host, h := range hosts {
+		for name := range h {
+			senders[name] = append(senders[name], host)
+		}
+	}
+
+	var names []string
+	for name, s := range senders {
+		share := float64(len(s)) / float64(len(hosts))
+		if share >= minShare && len(s) < len(hosts) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return
+	}
+
+	fmt.Printf("\nmissing headers:\n")
+	for _, name := range names {
+		var missing []string
+		for host, h := range hosts {
+			if _, ok := h[name]; !ok {
+				missing = append(missing, host)
+			}
+		}
+		sort.Strings(missing)
+
+		fmt.Printf("  %s (sent by %d/%d hosts)\n", name, len(senders[name]), len(hosts))
+		fmt.Printf("    missing: %s\n", strings.Join(missing, ", "))
+	}
+}
+
+// inconsistentHeaders prints the version headers that have
+// different values on different hosts, least common first
+func inconsistentHeaders(hosts map[string]http.Header) {
+	printed := false
+
+	for _, name := range versionHeaders {
+		byValue := make(map[string][]string)
+		for host, h := range hosts {
+			for _, v := range h[name] {
+				byValue[v] = append(byValue[v], host)
+			}
+		}
+
+		if len(byValue) < 2 {
+			continue
+		}
+
+		values := make([]string, 0, len(byValue))
+		for v := range byValue {
+			values = append(values, v)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			a, b := byValue[values[i]], byValue[values[j]]
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return values[i] < values[j]
+		})
+
+		if !printed {
+			fmt.Printf("\ninconsistent values:\n")
+			printed = true
+		}
+
+		fmt.Printf("  %s\n", name)
+		for _, v := range values {
+			h := byValue[v]
+			sort.Strings(h)
+			fmt.Printf("    %s: %d hosts (%s)\n", v, len(h), strings.Join(h, ", "))
+		}
+	}
+}
+
+// contains reports whether s is in values
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
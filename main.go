@@ -3,21 +3,42 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
+	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 func main() {
+	// subcommands work with the output of previous runs
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := runReport(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create report: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "headers" {
+		if err := runHeaders(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to analyze headers: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var concurrency int
 	flag.IntVar(&concurrency, "c", 20, "Concurrency level")
 
@@ -27,12 +48,303 @@ func main() {
 	var outputDir string
 	flag.StringVar(&outputDir, "o", "out", "Output directory")
 
+	var maxAttempts int
+	flag.IntVar(&maxAttempts, "max-attempts", 1, "Maximum number of attempts per URL")
+
+	var useDisposition bool
+	flag.BoolVar(&useDisposition, "content-disposition", false, "Name output files using Content-Disposition attachment filenames")
+
+	var dedupeSimilar float64
+	flag.Float64Var(&dedupeSimilar, "dedupe-similar", 0, "Skip saving responses more similar than this (0-1) to one already saved for the same host and status")
+
+	var storeSpec string
+	flag.StringVar(&storeSpec, "store", "", "Store output remotely instead of in the output dir (e.g. ssh://user@host/path)")
+
+	var resume bool
+	flag.BoolVar(&resume, "resume", false, "Skip URLs that already have an output file in the store")
+
+	var vhostsFile string
+	flag.StringVar(&vhostsFile, "vhosts", "", "File of Host headers to try against each URL, reporting responses that differ from the baseline")
+
+	var vhostSNI bool
+	flag.BoolVar(&vhostSNI, "vhosts-sni", false, "Use the vhost for TLS SNI as well as the Host header")
+
+	var vhostSimilarity float64
+	flag.Float64Var(&vhostSimilarity, "vhosts-similarity", 0.95, "Vhost responses at least this similar (0-1) to the baseline are not saved")
+
+	var rateHeaders bool
+	flag.BoolVar(&rateHeaders, "rate-headers", true, "Pace requests to each domain using the rate limit headers it sends")
+
+	var rateMaxWait int
+	flag.IntVar(&rateMaxWait, "rate-max-wait", 300000, "Longest rate limit headers can make requests wait; domains asking for longer are skipped (0 for no limit)")
+
+	var encryptSpec string
+	flag.StringVar(&encryptSpec, "encrypt", "", "Encrypt output files before storing them (e.g. age:recipients.txt)")
+
+	var redactPatterns string
+	flag.StringVar(&redactPatterns, "redact-patterns", "", "File of regular expressions for data to redact before output is stored")
+
+	var saveHeaders bool
+	flag.BoolVar(&saveHeaders, "save-headers", false, "Record the response headers in output files")
+
+	var dedupe bool
+	flag.BoolVar(&dedupe, "dedupe", false, "Skip duplicate input URLs, in bounded memory")
+
+	var dedupeFPRate float64
+	flag.Float64Var(&dedupeFPRate, "dedupe-fp-rate", 0.0001, "False positive rate for -dedupe")
+
+	var dedupeRecent int
+	flag.IntVar(&dedupeRecent, "dedupe-recent", 100000, "Number of recent URLs remembered exactly for -dedupe")
+
+	var captureDNS bool
+	flag.BoolVar(&captureDNS, "dns", false, "Resolve hostnames (once each) before they're requested and record the answers in output files")
+
+	var acceptProfile string
+	flag.StringVar(&acceptProfile, "accept-profile", "", "Accept header preset for the type of target (api, browser or feed)")
+
+	var accept string
+	flag.StringVar(&accept, "accept", "", "Accept header to send (overrides -accept-profile)")
+
+	var acceptRetry bool
+	flag.BoolVar(&acceptRetry, "accept-retry", false, "Retry with Accept: */* when the server responds 406 Not Acceptable")
+
+	var classifyCmd string
+	flag.StringVar(&classifyCmd, "classify-cmd", "", "Command to classify responses, speaking newline delimited JSON on stdin and stdout")
+
+	var classifyBodyKB int
+	flag.IntVar(&classifyBodyKB, "classify-body-kb", 64, "Kilobytes of each body to send to the classifier")
+
+	var classifyTimeout int
+	flag.IntVar(&classifyTimeout, "classify-timeout", 10000, "Time to wait for the classifier's verdict on each response")
+
+	var warmupHosts bool
+	flag.BoolVar(&warmupHosts, "warmup", false, "Connect to each host before requesting its URLs, skipping them if it fails")
+
+	var warmupTimeout int
+	flag.IntVar(&warmupTimeout, "warmup-timeout", 5000, "Timeout for -warmup connections")
+
+	var traceDir string
+	flag.StringVar(&traceDir, "trace-wire", "", "Directory to save traces of the raw bytes sent and received in")
+
+	var traceSample float64
+	flag.Float64Var(&traceSample, "trace-sample", 1, "Fraction (0-1) of requests to trace with -trace-wire")
+
+	var traceBodyKB int
+	flag.IntVar(&traceBodyKB, "trace-body-kb", 16, "Kilobytes of request and response bodies to keep in traces")
+
+	var normalizeRules string
+	flag.StringVar(&normalizeRules, "normalize-rules", "", "File of rules for normalizing input URLs (e.g. rules.yaml)")
+
+	var parallelChunks int
+	flag.IntVar(&parallelChunks, "parallel-chunks", 0, "Download large files as this many byte ranges concurrently")
+
+	var chunkMinSize int
+	flag.IntVar(&chunkMinSize, "chunk-min-size", 64, "Minimum size in megabytes for -parallel-chunks")
+
+	var maxSize int
+	flag.IntVar(&maxSize, "max-size", 0, "Maximum size in kilobytes of output to capture for each URL (0 for no limit)")
+
+	var maxStreamDuration int
+	flag.IntVar(&maxStreamDuration, "max-stream-duration", 0, "Maximum time to spend capturing output for each URL (0 for no limit)")
+
+	var runIDHeader string
+	flag.StringVar(&runIDHeader, "run-id-header", "", "Header to send a generated run ID in with every request (e.g. X-Scan-Id)")
+
+	var alertWebhook string
+	flag.StringVar(&alertWebhook, "alert-webhook", "", "Slack or Discord webhook URL to send alerts to")
+
+	var alertOn stringList
+	flag.Var(&alertOn, "alert-on", "Condition to alert on, like status=500, status=5xx or tag=exposed-git (can be repeated)")
+
+	var storeBodyFor string
+	flag.StringVar(&storeBodyFor, "store-body-for", "", "Only store bodies for these statuses (e.g. 200-299,401,403), storing just the metadata for the rest")
+
 	flag.Parse()
 
-	// channel to send URLs to workers
-	jobs := make(chan string)
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
 
-	rl := newRateLimiter(time.Duration(delay * 1000000))
+	if chunkMinSize < 0 {
+		fmt.Fprintf(os.Stderr, "-chunk-min-size can't be negative\n")
+		os.Exit(1)
+	}
+
+	// ranges can't be reassembled if the
+	// headers are included in the output
+	for _, arg := range flag.Args() {
+		if parallelChunks > 1 && (arg == "-i" || arg == "--include") {
+			fmt.Fprintf(os.Stderr, "-parallel-chunks can't be used with curl's %s option\n", arg)
+			os.Exit(1)
+		}
+	}
+
+	limits := captureLimits{
+		maxSize:     maxSize * 1024,
+		maxDuration: time.Duration(maxStreamDuration * 1000000),
+	}
+
+	if dedupeFPRate <= 0 || dedupeFPRate >= 1 {
+		fmt.Fprintf(os.Stderr, "-dedupe-fp-rate must be between 0 and 1\n")
+		os.Exit(1)
+	}
+
+	if accept == "" && acceptProfile != "" {
+		accept = acceptProfiles[acceptProfile]
+		if accept == "" {
+			fmt.Fprintf(os.Stderr, "unknown accept profile %q\n", acceptProfile)
+			os.Exit(1)
+		}
+	}
+
+	st, err := newStore(storeSpec, outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set up store: %s\n", err)
+		os.Exit(1)
+	}
+
+	var bodyFor statusFilter
+	if storeBodyFor != "" {
+		bodyFor, err = parseStatusFilter(storeBodyFor)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -store-body-for: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var dns *dnsCache
+	if captureDNS {
+		dns, err = newDNSCache()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set up DNS capture: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var vhosts []string
+	if vhostsFile != "" {
+		vhosts, err = readLines(vhostsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read vhosts: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// channel to send jobs to workers
+	jobs := make(chan job)
+
+	var enc *encrypter
+	if encryptSpec != "" {
+		enc, err = newEncrypter(encryptSpec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set up encryption: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var red *redactor
+	if redactPatterns != "" {
+		red, err = newRedactor(redactPatterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read redaction patterns: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// an interrupted run stops making requests and kills any
+	// that are in flight, but still finishes up everything
+	// else (e.g. sending alerts) so that it can be resumed.
+	// Interrupting it again exits straight away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	var cl *classifier
+	if classifyCmd != "" {
+		cl, err = newClassifier(ctx, classifyCmd, classifyBodyKB*1024, time.Duration(classifyTimeout*1000000))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start classifier: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var warm *warmer
+	if warmupHosts {
+		// connecting directly says nothing about whether
+		// requests that go somewhere else will work
+		if bypass := warmupBypass(flag.Args()); bypass != "" {
+			fmt.Fprintf(os.Stderr, "not warming up hosts: requests don't go to them directly (%s)\n", bypass)
+		} else {
+			warm = newWarmer(time.Duration(warmupTimeout * 1000000))
+		}
+	}
+
+	var tracer *wireTracer
+	if traceDir != "" {
+		tracer = &wireTracer{
+			dir:       traceDir,
+			sample:    traceSample,
+			dataLimit: traceBodyKB * 1024,
+			red:       red,
+			enc:       enc,
+		}
+	}
+
+	if len(alertOn) > 0 && alertWebhook == "" {
+		fmt.Fprintf(os.Stderr, "-alert-on needs -alert-webhook\n")
+		os.Exit(1)
+	}
+	if alertWebhook != "" && len(alertOn) == 0 {
+		fmt.Fprintf(os.Stderr, "-alert-webhook needs at least one -alert-on\n")
+		os.Exit(1)
+	}
+
+	var alerts *alerter
+	if alertWebhook != "" {
+		var rules []alertRule
+		for _, raw := range alertOn {
+			r, err := parseAlertRule(raw)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			rules = append(rules, r)
+		}
+		alerts = newAlerter(alertWebhook, rules)
+	}
+
+	// identify the run to whoever is on the other end,
+	// and keep a record of it for when they ask
+	runID := ""
+	if runIDHeader != "" {
+		runID, err = newRunID()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to generate run ID: %s\n", err)
+			os.Exit(1)
+		}
+
+		p, err := saveManifest(st, manifest{
+			RunID:   runID,
+			Header:  runIDHeader,
+			Started: time.Now(),
+			Host:    hostname(),
+			Args:    os.Args[1:],
+		}, red, enc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save manifest: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "run %s: %s\n", runID, p)
+	}
+
+	rl := newRateLimiter(time.Duration(delay*1000000), time.Duration(rateMaxWait*1000000))
+
+	var similar *similarityIndex
+	if dedupeSimilar > 0 {
+		similar = newSimilarityIndex(dedupeSimilar)
+	}
 
 	var wg sync.WaitGroup
 
@@ -40,58 +352,372 @@ func main() {
 		wg.Add(1)
 
 		go func() {
-			for u := range jobs {
+			for j := range jobs {
+				u := j.url
+
+				// drain the remaining jobs once interrupted
+				if ctx.Err() != nil {
+					if j.isBaseline() {
+						j.baseline.set(response{}, ctx.Err())
+					}
+					continue
+				}
 
 				// get the domain for use in the path
 				// and for rate limiting
 				domain := "unknown"
-				parsed, err := url.Parse(u)
+				parsed, err := url.Parse(j.origin())
 				if err == nil {
 					domain = parsed.Hostname()
 				}
 
-				// rate limit requests to the same domain
-				rl.Block(domain)
-
 				// we need the silent flag to get rid
 				// of the progress output
 				args := []string{"--silent", u}
+				args = append(args, j.args...)
+
+				acceptIdx := -1
+				if accept != "" {
+					args = append(args, "-H", "Accept: "+accept)
+					acceptIdx = len(args) - 1
+				}
 
 				// pass all the arguments on to curl
 				args = append(args, flag.Args()...)
-				cmd := exec.Command("curl", args...)
 
-				out, err := cmd.CombinedOutput()
-				if err != nil {
-					fmt.Printf("failed to get output: %s\n", err)
-					continue
+				// use a hash of the URL and the arguments as the filename
+				id := fmt.Sprintf("%x", sha1.Sum([]byte(u+strings.Join(args, " "))))
+				filename := id
+				if enc != nil {
+					filename += ".age"
 				}
 
-				// use a hash of the URL and the arguments as the filename
-				filename := fmt.Sprintf("%x", sha1.Sum([]byte(u+strings.Join(args, " "))))
-				p := filepath.Join(outputDir, domain, filename)
+				// the run ID is left out of the filename so that runs
+				// can be resumed, but it's still recorded in the
+				// command at the top of the output file
+				if runID != "" {
+					args = append(args, "-H", runIDHeader+": "+runID)
+				}
+
+				// the filename only depends on the request, so when
+				// resuming an interrupted run it tells us whether
+				// there's anything left to do for the URL
+				if resume && !j.isBaseline() {
+					exists, err := st.Exists(domain, filename)
+					if err == nil && !exists {
+						exists, err = st.Exists(domain, markerName(filename))
+					}
+					if err != nil {
+						fmt.Printf("failed to check for existing output: %s\n", err)
+						continue
+					}
+					if exists {
+						fmt.Printf("skipping %s: already saved\n", u)
+						continue
+					}
+				}
+
+				// don't waste time on hosts that can't be connected to
+				if warm != nil && parsed != nil {
+					if err := warm.Check(ctx, parsed); err != nil {
+						if ctx.Err() == nil {
+							fmt.Printf("skipping %s: warm-up failed: %s\n", u, err)
+						}
+						if j.isBaseline() {
+							j.baseline.set(response{}, err)
+						}
+						continue
+					}
+				}
+
+				// resolve the hostname ourselves and have curl use
+				// the answer, so that the addresses recorded are
+				// definitely the ones that were used
+				var answer dnsAnswer
+				var pin []string
+				if dns != nil && domain != "" && domain != "unknown" && net.ParseIP(domain) == nil {
+					answer = dns.Resolve(ctx, domain)
+					pin = answer.resolveArgs(domain, urlPort(parsed))
+				}
+
+				traced := tracer != nil && tracer.Sample()
+
+				// retry failed requests until the attempt
+				// budget for the URL is used up
+				var resp response
+				var attempts []attempt
+				budget := maxAttempts
+				retriedAccept := false
+				for len(attempts) < budget {
+					// rate limit requests to the same domain
+					if err := rl.Block(ctx, domain); err != nil {
+						attempts = append(attempts, attempt{start: time.Now(), err: err})
+						break
+					}
+
+					var trace *traceWriter
+					if traced {
+						trace = tracer.Start()
+					}
+
+					a := attempt{start: time.Now()}
+					curlArgs := append(pin, args...)
 
-				if _, err := os.Stat(path.Dir(p)); os.IsNotExist(err) {
-					err = os.MkdirAll(path.Dir(p), 0755)
+					// the rate limit applies to downloads as a whole
+					// rather than each chunk. Traces of concurrent
+					// chunks would be a jumble, so traced requests
+					// are always downloaded normally
+					a.err = errNotChunkable
+					if parallelChunks > 1 && !traced {
+						resp, a.err = fetchChunked(ctx, curlArgs, parallelChunks, int64(chunkMinSize)*1024*1024, limits.maxSize)
+					}
+					if a.err == errNotChunkable {
+						resp, a.err = fetchLimited(ctx, curlArgs, limits, trace)
+					}
+					a.status = resp.status
+					attempts = append(attempts, a)
+
+					if trace != nil {
+						if err := tracer.Finish(trace, traceName(id, len(attempts))); err != nil {
+							fmt.Printf("failed to save trace: %s\n", err)
+						}
+					}
+
+					// servers that advertise their limits tell
+					// us how fast we can go better than -d does
+					if rateHeaders {
+						if remaining, reset, ok := parseRateLimit(resp.status, resp.headers); ok {
+							rl.Learn(domain, remaining, reset)
+						}
+					}
+
+					// servers that only have one representation of
+					// a resource can refuse to send anything else;
+					// trying again for it doesn't count as a retry
+					if a.err == nil && resp.status == http.StatusNotAcceptable && acceptRetry && acceptIdx != -1 && !retriedAccept {
+						args[acceptIdx] = "Accept: */*"
+						retriedAccept = true
+						budget++
+						continue
+					}
+
+					if a.err == nil {
+						break
+					}
+				}
+
+				// sensitive data is redacted before anything else
+				// sees the response so it can't leak anywhere
+				cmdLine := strings.Join(args, " ")
+				safeURL := u
+				redactions := 0
+				if red != nil {
+					var n int
+					cmdLine, n = red.RedactString(cmdLine)
+					redactions = n + red.RedactResponse(&resp)
+
+					// the URL is part of the command, so any
+					// redactions in it have already been counted
+					safeURL, _ = red.RedactString(u)
+				}
+
+				// vhost responses are compared to the baseline
+				// so they need it whether it worked or not
+				if j.isBaseline() {
+					j.baseline.set(resp, attempts[len(attempts)-1].err)
+				}
+
+				// requests cut short by an interrupt aren't failures;
+				// they're left for -resume to make again
+				if err := attempts[len(attempts)-1].err; err != nil {
+					if errors.Is(err, errRateLimited) {
+						fmt.Printf("skipping %s: %s\n", u, err)
+						continue
+					}
+					if ctx.Err() != nil {
+						continue
+					}
+					fmt.Printf("failed to get output after %d attempt(s): %s\n", len(attempts), err)
+
+					// with retries, the attempts that were made are
+					// worth keeping even though nothing came of them
+					if maxAttempts < 2 {
+						continue
+					}
+					data := failedOutput(cmdLine, attempts)
+					if enc != nil {
+						data, err = enc.Encrypt(data)
+						if err != nil {
+							fmt.Printf("failed to encrypt output: %s\n", err)
+							continue
+						}
+					}
+					p, err := st.Save(domain, filename, data)
 					if err != nil {
-						fmt.Printf("failed to create output dir: %s\n", err)
+						fmt.Printf("failed to save output: %s\n", err)
+						continue
+					}
+					fmt.Printf("%s %s (failed)\n", p, u)
+					continue
+				}
+
+				// a vhost is only interesting if the server
+				// responds differently when it's used
+				sim := 0.0
+				if j.vhost != "" {
+					sim = j.baseline.Similarity(resp)
+					if sim >= vhostSimilarity {
+						continue
+					}
+				}
+
+				// parameter permutations often produce near
+				// identical responses that aren't worth keeping
+				if similar != nil {
+					if orig := similar.Check(domain, resp.status, u, resp.out); orig != "" {
+						fmt.Printf("skipping %s: similar to %s\n", u, orig)
 						continue
 					}
 				}
 
+				// downloads are more useful with the name the
+				// server suggested than with a hash for a name
+				suggested := ""
+				if useDisposition {
+					suggested = dispositionFilename(resp.headers)
+				}
+
+				// have the external classifier triage the response
+				// so its verdict can be recorded alongside it
+				var v verdict
+				if cl != nil {
+					v, err = cl.Classify(ctx, safeURL, htmlTitle(resp.out), resp)
+					if err != nil && ctx.Err() == nil {
+						fmt.Printf("failed to classify %s: %s\n", u, err)
+					}
+				}
+
 				// include the command at the top of the output file
 				buf := &bytes.Buffer{}
 				buf.WriteString("cmd: curl ")
-				buf.WriteString(strings.Join(args, " "))
-				buf.WriteString("\n------\n\n")
-				buf.Write(out)
+				buf.WriteString(cmdLine)
+				buf.WriteString("\n")
+				fmt.Fprintf(buf, "status: %d\n", resp.status)
+
+				// record the attempt history when retries
+				// are enabled so flaky targets stand out
+				if maxAttempts > 1 || retriedAccept {
+					for i, a := range attempts {
+						fmt.Fprintf(buf, "attempt: %d %s\n", i+1, a)
+					}
+				}
+
+				if suggested != "" {
+					fmt.Fprintf(buf, "content-disposition: %s\n", suggested)
+				}
+
+				v.record(buf)
+
+				if resp.capture != "" {
+					fmt.Fprintf(buf, "capture: %s\n", resp.capture)
+				}
+
+				if resp.chunks > 0 {
+					fmt.Fprintf(buf, "chunks: %d\n", resp.chunks)
+					fmt.Fprintf(buf, "sha256: %x\n", sha256.Sum256(resp.out))
+				}
+
+				if retriedAccept {
+					fmt.Fprintf(buf, "accept-refused: %s\n", accept)
+				}
+
+				if len(answer.addrs) > 0 || answer.err != nil {
+					answer.record(buf)
+				}
+
+				if red != nil {
+					fmt.Fprintf(buf, "redactions: %d\n", redactions)
+				}
+
+				if j.vhost != "" {
+					fmt.Fprintf(buf, "vhost: %s\n", j.vhost)
+					fmt.Fprintf(buf, "baseline-similarity: %.2f\n", sim)
+				}
 
-				err = ioutil.WriteFile(p, buf.Bytes(), 0644)
+				if saveHeaders {
+					names := make([]string, 0, len(resp.headers))
+					for name := range resp.headers {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+
+					for _, name := range names {
+						for _, v := range resp.headers[name] {
+							fmt.Fprintf(buf, "header: %s: %s\n", name, v)
+						}
+					}
+				}
+
+				// on broad sweeps most bodies aren't worth the
+				// space, but their statuses still are
+				storeBody := bodyFor == nil || bodyFor.Match(resp.status)
+				if !storeBody {
+					fmt.Fprintf(buf, "body: not stored (%d bytes)\n", len(resp.out))
+				}
+
+				buf.WriteString("------\n\n")
+				if storeBody {
+					buf.Write(resp.out)
+				}
+
+				// large bodies shouldn't be held twice over
+				// while the output is encrypted and saved
+				resp.out = nil
+
+				// nothing captured should touch the disk unencrypted
+				data := buf.Bytes()
+				if enc != nil {
+					data, err = enc.Encrypt(data)
+					if err != nil {
+						fmt.Printf("failed to encrypt output: %s\n", err)
+						continue
+					}
+				}
+
+				var p string
+				if name := sanitizeFilename(suggested); name != "" {
+					if enc != nil {
+						name += ".age"
+					}
+					p, err = st.SaveUnique(domain, name, data)
+
+					// the name can't be worked out from the request,
+					// so the usual name is used for a marker that
+					// says the request was saved, for -resume
+					if err == nil {
+						if _, merr := st.Save(domain, markerName(filename), []byte(p+"\n")); merr != nil {
+							fmt.Printf("failed to save output marker: %s\n", merr)
+						}
+					}
+				} else {
+					p, err = st.Save(domain, filename, data)
+				}
 				if err != nil {
 					fmt.Printf("failed to save output: %s\n", err)
 					continue
 				}
 
+				// high-signal results shouldn't wait for someone
+				// to go looking through the output dir
+				if alerts != nil {
+					alerts.Check(safeURL, p, domain, resp.status, v.Labels)
+				}
+
+				if j.vhost != "" {
+					fmt.Printf("%s %s (vhost %s)\n", p, u, j.vhost)
+					continue
+				}
+
 				fmt.Printf("%s %s\n", p, u)
 			}
 
@@ -99,12 +725,136 @@ func main() {
 		}()
 	}
 
-	sc := bufio.NewScanner(os.Stdin)
-	for sc.Scan() {
+	var norm *normalizer
+	if normalizeRules != "" {
+		norm, err = newNormalizer(normalizeRules)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read normalization rules: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var seen *seenSet
+	if dedupe {
+		seen = newSeenSet(dedupeFPRate, dedupeRecent)
+	}
+	duplicates := 0
+
+	// read the input separately so that waiting for
+	// more of it doesn't hold up an interrupted run
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+
+input:
+	for {
+		var u string
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				break input
+			}
+			u = line
+		case <-ctx.Done():
+			break input
+		}
+
+		// normalize URLs first so that everything else
+		// (e.g. deduplication and filenames) sees the same
+		// URL for URLs that only differ superficially
+		if norm != nil {
+			u = norm.Normalize(u)
+		}
+
+		if seen != nil && seen.Seen(u) {
+			duplicates++
+			continue
+		}
+
 		// send each line (a domain) on the jobs channel
-		jobs <- sc.Text()
+		if len(vhosts) == 0 {
+			jobs <- job{url: u}
+			continue
+		}
+
+		// the baseline job is sent first so that it's always
+		// picked up before the jobs that wait for it
+		for _, j := range vhostJobs(u, vhosts, vhostSNI) {
+			jobs <- j
+		}
 	}
 
 	close(jobs)
 	wg.Wait()
+
+	if alerts != nil {
+		if dropped := alerts.Close(); dropped > 0 {
+			fmt.Fprintf(os.Stderr, "dropped %d alerts because the webhook couldn't keep up\n", dropped)
+		}
+	}
+
+	if cl != nil {
+		if err := cl.Close(); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "classifier exited with error: %s\n", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "interrupted; use -resume to pick up where the run left off\n")
+	}
+
+	if duplicates > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d duplicate URLs\n", duplicates)
+	}
+
+	// report the rates learned from rate limit headers
+	// so that they can be used with -d in future runs
+	learned := rl.Learned()
+	domains := make([]string, 0, len(learned))
+	for d := range learned {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+
+	for _, d := range domains {
+		fmt.Fprintf(os.Stderr, "learned delay for %s: %s\n", d, learned[d])
+	}
+
+	// and the domains that wanted us to wait too long
+	refused := rl.Refused()
+	domains = domains[:0]
+	for d := range refused {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+
+	for _, d := range domains {
+		fmt.Fprintf(os.Stderr, "skipped %s: rate limited until %s\n", d, refused[d].Format(time.RFC3339))
+	}
+}
+
+// readLines returns the non-empty lines of a file,
+// skipping any comments that start with a #
+func readLines(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// a normalizer rewrites URLs according to a set of rules so
+// that URLs which are effectively the same become identical,
+// making deduplication and output filenames predictable
+type normalizer struct {
+	stripParams        []*regexp.Regexp
+	collapseSlashes    bool
+	resolveDotSegments bool
+	forceScheme        string
+	lowercaseHost      bool
+	sortParams         bool
+	dropFragment       bool
+}
+
+// newNormalizer returns a *normalizer for a rules file. Rules
+// files are a small subset of YAML: keys with scalar values,
+// or with a list of values on the lines that follow, e.g.
+//
+//	strip-params:
+//	  - ^utm_
+//	  - ^fbclid$
+//	collapse-slashes: true
+//	force-scheme: https
+func newNormalizer(filename string) (*normalizer, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := parseRules(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	n := &normalizer{}
+	for key, values := range rules {
+		switch key {
+		case "strip-params":
+			for _, v := range values {
+				re, err := regexp.Compile(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid strip-params pattern %q: %s", v, err)
+				}
+				n.stripParams = append(n.stripParams, re)
+			}
+		case "collapse-slashes":
+			n.collapseSlashes, err = parseRuleBool(key, values)
+		case "resolve-dot-segments":
+			n.resolveDotSegments, err = parseRuleBool(key, values)
+		case "lowercase-host":
+			n.lowercaseHost, err = parseRuleBool(key, values)
+		case "sort-params":
+			n.sortParams, err = parseRuleBool(key, values)
+		case "drop-fragment":
+			n.dropFragment, err = parseRuleBool(key, values)
+		case "force-scheme":
+			if len(values) != 1 {
+				return nil, fmt.Errorf("force-scheme needs a single value")
+			}
+			n.forceScheme = strings.ToLower(values[0])
+		default:
+			return nil, fmt.Errorf("unknown rule %q", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// parseRules parses the YAML subset used for rules files
+// into a map of keys to their values
+func parseRules(data string) (map[string][]string, error) {
+	rules := make(map[string][]string)
+	key := ""
+
+	for i, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(stripComment(line))
+		if trimmed == "" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if key == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", i+1)
+			}
+			rules[key] = append(rules[key], unquote(strings.TrimSpace(trimmed[1:])))
+			continue
+		}
+
+		parts := strings.SplitN(trimmed, ":", 2)
+		if len(parts) != 2 || line != strings.TrimLeft(line, " \t") {
+			return nil, fmt.Errorf("line %d: expected 'key: value'", i+1)
+		}
+
+		key = strings.TrimSpace(parts[0])
+		if _, ok := rules[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", i+1, key)
+		}
+		rules[key] = nil
+
+		if v := strings.TrimSpace(parts[1]); v != "" {
+			rules[key] = append(rules[key], unquote(v))
+		}
+	}
+
+	return rules, nil
+}
+
+// stripComment removes a # comment from a line, as long as
+// the # is at the start or follows whitespace (so that
+// patterns like [#] keep working)
+func stripComment(line string) string {
+	for i, r := range line {
+		if r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote removes the quotes from a quoted value
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// parseRuleBool parses the value of a boolean rule
+func parseRuleBool(key string, values []string) (bool, error) {
+	if len(values) == 1 {
+		switch strings.ToLower(values[0]) {
+		case "true", "yes", "on":
+			return true, nil
+		case "false", "no", "off":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("%s needs to be true or false", key)
+}
+
+// Normalize returns raw rewritten according to the rules.
+// Anything that can't be parsed as a URL is left alone
+func (n *normalizer) Normalize(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	if n.forceScheme != "" {
+		u.Scheme = n.forceScheme
+	}
+
+	if n.lowercaseHost {
+		u.Host = strings.ToLower(u.Host)
+	}
+
+	p := u.EscapedPath()
+	if n.collapseSlashes {
+		for strings.Contains(p, "//") {
+			p = strings.ReplaceAll(p, "//", "/")
+		}
+	}
+	if n.resolveDotSegments {
+		p = removeDotSegments(p)
+	}
+	if p != u.EscapedPath() {
+		if unescaped, err := url.PathUnescape(p); err == nil {
+			u.Path = unescaped
+			u.RawPath = p
+		}
+	}
+
+	// work on the raw query so that parameters that
+	// are kept aren't encoded any differently
+	if u.RawQuery != "" && (len(n.stripParams) > 0 || n.sortParams) {
+		var kept []string
+		for _, pair := range strings.Split(u.RawQuery, "&") {
+			name := strings.SplitN(pair, "=", 2)[0]
+			if unescaped, err := url.QueryUnescape(name); err == nil {
+				name = unescaped
+			}
+
+			if !matchesAny(n.stripParams, name) {
+				kept = append(kept, pair)
+			}
+		}
+
+		if n.sortParams {
+			sort.Strings(kept)
+		}
+		u.RawQuery = strings.Join(kept, "&")
+	}
+
+	if n.dropFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	return u.String()
+}
+
+// matchesAny reports whether s matches any of the patterns
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeDotSegments resolves the . and .. segments in
+// a path, as described in section 5.2.4 of RFC 3986.
+// Percent-encoded dots (%2E) count as dots too, since
+// they mean the same thing
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") && !strings.Contains(strings.ToLower(p), "%2e") {
+		return p
+	}
+
+	var out []string
+	segments := strings.Split(p, "/")
+
+	for i, s := range segments {
+		last := i == len(segments)-1
+
+		switch strings.ReplaceAll(strings.ToLower(s), "%2e", ".") {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			// never remove the empty segment
+			// before the leading slash
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, s)
+		}
+	}
+
+	return strings.Join(out, "/")
+}
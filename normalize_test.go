@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	cases := []struct {
+		in   string
+		want map[string][]string
+		err  bool
+	}{
+		{"collapse-slashes: true", map[string][]string{"collapse-slashes": {"true"}}, false},
+		{"strip-params:\n  - ^utm_\n  - '^fbclid$'\n", map[string][]string{"strip-params": {"^utm_", "^fbclid$"}}, false},
+		{"# comment\nforce-scheme: https # trailing\n", map[string][]string{"force-scheme": {"https"}}, false},
+		{"strip-params:\n  - ^a[#]b\n", map[string][]string{"strip-params": {"^a[#]b"}}, false},
+		{"force-scheme: \"http\"", map[string][]string{"force-scheme": {"http"}}, false},
+		{"strip-params:\n  -\n", map[string][]string{"strip-params": {""}}, false},
+		{"strip-params:\n", map[string][]string{"strip-params": nil}, false},
+		{"- ^utm_", nil, true},
+		{"no colon here", nil, true},
+		{"  indented: true", nil, true},
+		{"sort-params: true\nsort-params: false", nil, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseRules(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseRules(%q): expected an error, got %v", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRules(%q): %s", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseRules(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRemoveDotSegments(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"/a/b/c", "/a/b/c"},
+		{"/a/./b", "/a/b"},
+		{"/a/b/../c", "/a/c"},
+		{"/a/b/..", "/a/"},
+		{"/a/b/.", "/a/b/"},
+		{"/a/b/./", "/a/b/"},
+		{"/..", "/"},
+		{"/../../a", "/a"},
+		{"/a/../../b", "/b"},
+		{"/a/.../b", "/a/.../b"},
+		{"/a/..b/c", "/a/..b/c"},
+		{"/a/.b/c", "/a/.b/c"},
+		{"/a/%2E%2E/b", "/b"},
+		{"/a/%2e/b", "/a/b"},
+		{"/a%2F..%2Fb", "/a%2F..%2Fb"},
+		{"/a/b%2F../c", "/a/b%2F../c"},
+	}
+
+	for _, c := range cases {
+		if got := removeDotSegments(c.in); got != c.want {
+			t.Errorf("removeDotSegments(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		n        normalizer
+		in, want string
+	}{
+		{normalizer{}, "https://example.com/a?b=1#c", "https://example.com/a?b=1#c"},
+		{normalizer{}, "not a url", "not a url"},
+		{normalizer{collapseSlashes: true}, "https://example.com//a///b", "https://example.com/a/b"},
+		{normalizer{collapseSlashes: true}, "https://example.com/a%2F%2Fb", "https://example.com/a%2F%2Fb"},
+		{normalizer{resolveDotSegments: true}, "https://example.com/a/b/../c/.", "https://example.com/a/c/"},
+		{normalizer{resolveDotSegments: true}, "https://example.com/a%2F..%2Fb", "https://example.com/a%2F..%2Fb"},
+		{normalizer{resolveDotSegments: true}, "https://example.com/../../etc/passwd", "https://example.com/etc/passwd"},
+		{normalizer{forceScheme: "https"}, "http://example.com/", "https://example.com/"},
+		{normalizer{lowercaseHost: true}, "https://EXAMPLE.com/Path", "https://example.com/Path"},
+		{normalizer{sortParams: true}, "https://example.com/?b=2&a=1", "https://example.com/?a=1&b=2"},
+		{normalizer{dropFragment: true}, "https://example.com/a#frag", "https://example.com/a"},
+		{normalizer{sortParams: true}, "https://example.com/?q=a%20b&a=%2F", "https://example.com/?a=%2F&q=a%20b"},
+	}
+
+	for _, c := range cases {
+		if got := c.n.Normalize(c.in); got != c.want {
+			t.Errorf("Normalize(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeStripParams(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := ioutil.WriteFile(rules, []byte("strip-params:\n  - ^utm_\n  - ^fbclid$\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := newNormalizer(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		in, want string
+	}{
+		{"https://example.com/?utm_source=x&id=1", "https://example.com/?id=1"},
+		{"https://example.com/?utm%5Fsource=x&id=1", "https://example.com/?id=1"},
+		{"https://example.com/?fbclid=1&fbclid2=2", "https://example.com/?fbclid2=2"},
+		{"https://example.com/?utm_source=x", "https://example.com/"},
+	}
+
+	for _, c := range cases {
+		if got := n.Normalize(c.in); got != c.want {
+			t.Errorf("Normalize(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
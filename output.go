@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// outputSeparator separates the details recorded at the
+// top of an output file from the output itself
+const outputSeparator = "\n------\n\n"
+
+// an outputFile is an output file read back from an
+// output directory, for reporting and analysis
+type outputFile struct {
+	path   string
+	domain string
+	meta   map[string][]string
+
+	// the start of the output, and the size of all of it
+	prefix []byte
+	size   int
+}
+
+// Get returns the first value recorded for key
+func (f outputFile) Get(key string) string {
+	if v := f.meta[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// URL returns the URL that was requested, which is the
+// first argument to curl that isn't an option
+func (f outputFile) URL() string {
+	for _, arg := range strings.Fields(f.Get("cmd")) {
+		if arg != "curl" && !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// Status returns the recorded status code, or 0
+func (f outputFile) Status() int {
+	status, _ := strconv.Atoi(f.Get("status"))
+	return status
+}
+
+// Headers returns the response headers recorded in
+// the output file with -save-headers
+func (f outputFile) Headers() http.Header {
+	h := http.Header{}
+	for _, line := range f.meta["header"] {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) == 2 {
+			h.Add(parts[0], parts[1])
+		}
+	}
+	return h
+}
+
+var titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// Title returns the title of an HTML body
+func (f outputFile) Title() string {
+	return htmlTitle(f.prefix)
+}
+
+// Size returns the size of the output, including
+// output that wasn't stored because of -store-body-for
+func (f outputFile) Size() int {
+	var n int
+	if _, err := fmt.Sscanf(f.Get("body"), "not stored (%d bytes)", &n); err == nil {
+		return n
+	}
+	return f.size
+}
+
+// htmlTitle returns the contents of the title element of an
+// HTML document with whitespace collapsed, or an empty string
+func htmlTitle(body []byte) string {
+	m := titleRe.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(string(m[1])), " ")
+}
+
+// outputPrefix is how much of the output is read back from
+// each output file, which is plenty to find a title in
+const outputPrefix = 64 * 1024
+
+// readOutputFile reads the details recorded at the top of the
+// output file at p, along with the first outputPrefix bytes of
+// the output and its size
+func readOutputFile(p string) (map[string][]string, []byte, int, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	meta := make(map[string][]string)
+	r := bufio.NewReader(f)
+	read := 0
+
+	for {
+		line, err := r.ReadString('\n')
+		read += len(line)
+		if line == "------\n" {
+			break
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(line, "\n"), ": ", 2)
+		if len(parts) == 2 {
+			meta[parts[0]] = append(meta[parts[0]], parts[1])
+		}
+
+		// without a separator it's all details
+		if err == io.EOF {
+			return meta, nil, 0, nil
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	// skip the blank line after the separator
+	if b, err := r.ReadByte(); err == nil {
+		if b != '\n' {
+			r.UnreadByte()
+		} else {
+			read++
+		}
+	}
+
+	prefix := make([]byte, outputPrefix)
+	n, err := io.ReadFull(r, prefix)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, 0, err
+	}
+
+	return meta, prefix[:n], int(info.Size()) - read, nil
+}
+
+// readOutputDir reads all of the output files in dir. Encrypted
+// output files can't be read, so they are counted and skipped
+func readOutputDir(dir string) ([]outputFile, int, error) {
+	domains, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var files []outputFile
+	encrypted := 0
+
+	for _, d := range domains {
+		if !d.IsDir() {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(filepath.Join(dir, d.Name()))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, e := range entries {
+			name := e.Name()
+
+			// skip temporary files and anything that
+			// isn't an output file itself
+			if e.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".png") {
+				continue
+			}
+
+			if strings.HasSuffix(name, ".age") {
+				encrypted++
+				continue
+			}
+
+			p := filepath.Join(dir, d.Name(), name)
+			meta, prefix, size, err := readOutputFile(p)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, 0, err
+			}
+
+			if len(meta["cmd"]) == 0 {
+				continue
+			}
+
+			files = append(files, outputFile{
+				path:   p,
+				domain: d.Name(),
+				meta:   meta,
+				prefix: prefix,
+				size:   size,
+			})
+		}
+	}
+
+	return files, encrypted, nil
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,39 +18,67 @@ type rateLimiter struct {
 	sync.Mutex
 	delay time.Duration
 	ops   map[string]time.Time
+
+	// delays learned for keys from the rate limit
+	// headers sent by servers
+	learned map[string]time.Duration
+
+	// the longest servers can have operations wait, and
+	// the keys for servers that wanted them to wait longer,
+	// which are refused until their limits reset
+	maxWait time.Duration
+	refused map[string]time.Time
 }
 
-// newRateLimiter returns a new *rateLimiter for the
-// provided delay
-func newRateLimiter(delay time.Duration) *rateLimiter {
+// errRateLimited is returned by Block for keys whose servers
+// asked for operations to wait for longer than the maximum
+var errRateLimited = errors.New("rate limited")
+
+// newRateLimiter returns a new *rateLimiter for the provided
+// delay, which never waits longer than maxWait for rate limit
+// headers (or for as long as they say if maxWait is 0)
+func newRateLimiter(delay, maxWait time.Duration) *rateLimiter {
 	return &rateLimiter{
-		delay: delay,
-		ops:   make(map[string]time.Time),
+		delay:   delay,
+		ops:     make(map[string]time.Time),
+		learned: make(map[string]time.Duration),
+		maxWait: maxWait,
+		refused: make(map[string]time.Time),
 	}
 }
 
-// Block blocks until an operation for key is
-// allowed to proceed
-func (r *rateLimiter) Block(key string) {
+// Block blocks until an operation for key is allowed
+// to proceed, or until ctx is cancelled, in which case
+// the context's error is returned. Operations for keys
+// that have been refused fail with errRateLimited
+func (r *rateLimiter) Block(ctx context.Context, key string) error {
 	now := time.Now()
 
 	r.Lock()
 
+	if until, ok := r.refused[key]; ok {
+		if now.Before(until) {
+			r.Unlock()
+			return fmt.Errorf("%w until %s", errRateLimited, until.Format(time.RFC3339))
+		}
+		delete(r.refused, key)
+	}
+
 	// if there's nothing in the map we can
 	// return straight away
 	if _, ok := r.ops[key]; !ok {
 		r.ops[key] = now
 		r.Unlock()
-		return
+		return ctx.Err()
 	}
 
 	// if time is up we can return straight away
 	t := r.ops[key]
-	deadline := t.Add(r.delay)
+	deadline := t.Add(r.delayFor(key))
 	if now.After(deadline) {
 		r.ops[key] = now
 		r.Unlock()
-		return
+		return ctx.Err()
 	}
 
 	remaining := deadline.Sub(now)
@@ -54,5 +88,140 @@ func (r *rateLimiter) Block(key string) {
 	r.Unlock()
 
 	// Block for the remaining time
-	<-time.After(remaining)
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delayFor returns the delay for key; the learned
+// delay if there is one, or the default delay if not.
+// The caller must hold the lock
+func (r *rateLimiter) delayFor(key string) time.Duration {
+	if d, ok := r.learned[key]; ok {
+		return d
+	}
+	return r.delay
+}
+
+// Learn adjusts the delay for key so that the remaining
+// number of operations are spread out over the time until
+// the limit resets. When there are none remaining, the
+// next operation for key is blocked until the reset. If
+// either means waiting longer than the maximum, operations
+// for key are refused until the reset instead
+func (r *rateLimiter) Learn(key string, remaining int, reset time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	wait := reset
+	if remaining > 0 {
+		wait = reset / time.Duration(remaining)
+	}
+	if r.maxWait > 0 && wait > r.maxWait {
+		r.refused[key] = time.Now().Add(reset)
+		return
+	}
+
+	if remaining <= 0 {
+		// only ever push back operations that have
+		// already been allowed to go ahead
+		next := time.Now().Add(reset - r.delayFor(key))
+		if next.After(r.ops[key]) {
+			r.ops[key] = next
+		}
+		return
+	}
+
+	d := wait
+	if d < r.delay {
+		d = r.delay
+	}
+	r.learned[key] = d
+}
+
+// Refused returns the keys whose operations are being
+// refused, and the time until which they will be
+func (r *rateLimiter) Refused() map[string]time.Time {
+	r.Lock()
+	defer r.Unlock()
+
+	out := make(map[string]time.Time)
+	for k, until := range r.refused {
+		out[k] = until
+	}
+	return out
+}
+
+// Learned returns the delays learned for each
+// key that differ from the default delay
+func (r *rateLimiter) Learned() map[string]time.Duration {
+	r.Lock()
+	defer r.Unlock()
+
+	out := make(map[string]time.Duration)
+	for k, d := range r.learned {
+		if d != r.delay {
+			out[k] = d
+		}
+	}
+	return out
+}
+
+// parseRateLimit returns the number of requests remaining
+// and the time until that number resets from the rate
+// limit headers in a response, if there are any. Both the
+// X-RateLimit-* and draft standard RateLimit-* headers are
+// understood, as is Retry-After on 429 and 503 responses
+func parseRateLimit(status int, h http.Header) (int, time.Duration, bool) {
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		if reset, ok := parseReset(h.Get("Retry-After")); ok {
+			return 0, reset, true
+		}
+	}
+
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-", "X-Rate-Limit-"} {
+		remaining, err := strconv.Atoi(strings.TrimSpace(h.Get(prefix + "Remaining")))
+		if err != nil {
+			continue
+		}
+
+		reset, ok := parseReset(h.Get(prefix + "Reset"))
+		if !ok {
+			continue
+		}
+		return remaining, reset, true
+	}
+
+	return 0, 0, false
+}
+
+// parseReset parses the time until a rate limit resets, which
+// servers send as a number of seconds, a unix timestamp or an
+// HTTP date (for Retry-After)
+func parseReset(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	// anything this big must be a timestamp
+	if n > 1e9 {
+		return time.Until(time.Unix(int64(n), 0)), true
+	}
+	return time.Duration(n * float64(time.Second)), true
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseReset(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"   ", 0, false},
+		{"soon", 0, false},
+		{"-5", 0, false},
+		{"0", 0, true},
+		{"30", 30 * time.Second, true},
+		{" 30 ", 30 * time.Second, true},
+		{"1.5", 1500 * time.Millisecond, true},
+		{strconv.FormatInt(now.Add(time.Minute).Unix(), 10), time.Minute, true},
+		{now.Add(2 * time.Minute).UTC().Format(http.TimeFormat), 2 * time.Minute, true},
+		{now.Add(-time.Minute).UTC().Format(http.TimeFormat), -time.Minute, true},
+	}
+
+	for _, c := range cases {
+		got, ok := parseReset(c.in)
+		if ok != c.ok {
+			t.Errorf("parseReset(%q) ok = %v, want %v", c.in, ok, c.ok)
+			continue
+		}
+
+		// timestamps are only accurate to the second
+		if diff := got - c.want; diff < -2*time.Second || diff > 2*time.Second {
+			t.Errorf("parseReset(%q) = %s, want %s", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	cases := []struct {
+		status    int
+		headers   map[string]string
+		remaining int
+		reset     time.Duration
+		ok        bool
+	}{
+		{200, nil, 0, 0, false},
+		{200, map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "60"}, 10, time.Minute, true},
+		{200, map[string]string{"RateLimit-Remaining": "5", "RateLimit-Reset": "10"}, 5, 10 * time.Second, true},
+		{200, map[string]string{"X-Rate-Limit-Remaining": "0", "X-Rate-Limit-Reset": "1"}, 0, time.Second, true},
+		{200, map[string]string{"X-RateLimit-Remaining": "10"}, 0, 0, false},
+		{200, map[string]string{"X-RateLimit-Remaining": "lots", "X-RateLimit-Reset": "60"}, 0, 0, false},
+		{200, map[string]string{"Retry-After": "30"}, 0, 0, false},
+		{429, map[string]string{"Retry-After": "30"}, 0, 30 * time.Second, true},
+		{503, map[string]string{"Retry-After": "30"}, 0, 30 * time.Second, true},
+		{429, map[string]string{"Retry-After": "later", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "5"}, 0, 5 * time.Second, true},
+	}
+
+	for _, c := range cases {
+		h := http.Header{}
+		for name, v := range c.headers {
+			h.Set(name, v)
+		}
+
+		remaining, reset, ok := parseRateLimit(c.status, h)
+		if ok != c.ok || remaining != c.remaining || reset != c.reset {
+			t.Errorf("parseRateLimit(%d, %v) = %d, %s, %v, want %d, %s, %v",
+				c.status, c.headers, remaining, reset, ok, c.remaining, c.reset, c.ok)
+		}
+	}
+}
+
+func TestRateLimiterMaxWait(t *testing.T) {
+	cases := []struct {
+		remaining int
+		reset     time.Duration
+		refused   bool
+	}{
+		{0, time.Second, false},
+		{0, time.Minute, false},
+		{0, time.Hour, true},
+		{0, 100 * 365 * 24 * time.Hour, true},
+		{60, time.Hour, false},
+		{10, time.Hour, true},
+	}
+
+	for _, c := range cases {
+		r := newRateLimiter(0, time.Minute)
+		r.Learn("example.com", c.remaining, c.reset)
+
+		// refusing doesn't need a context that's still going
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := r.Block(ctx, "example.com")
+		if refused := errors.Is(err, errRateLimited); refused != c.refused {
+			t.Errorf("Learn(%d, %s): Block() = %v, want refused %v", c.remaining, c.reset, err, c.refused)
+		}
+		if _, ok := r.Refused()["example.com"]; ok != c.refused {
+			t.Errorf("Learn(%d, %s): Refused() has key %v, want %v", c.remaining, c.reset, ok, c.refused)
+		}
+		if d := r.Learned()["example.com"]; d > time.Minute {
+			t.Errorf("Learn(%d, %s): learned delay %s is over the maximum", c.remaining, c.reset, d)
+		}
+	}
+}
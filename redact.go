@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// redacted replaces anything matched by a redaction pattern
+var redacted = []byte("[REDACTED]")
+
+// a redactor replaces sensitive data (tokens, email addresses
+// and the like) in responses before they are stored
+type redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// newRedactor returns a *redactor for a file of regular
+// expressions, one per line
+func newRedactor(filename string) (*redactor, error) {
+	lines, err := readLines(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &redactor{}
+	for _, line := range lines {
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", line, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redact returns data with every match of every pattern
+// replaced, along with the number of replacements made
+func (r *redactor) Redact(data []byte) ([]byte, int) {
+	count := 0
+	for _, re := range r.patterns {
+		data = re.ReplaceAllFunc(data, func([]byte) []byte {
+			count++
+			return redacted
+		})
+	}
+	return data, count
+}
+
+// RedactString is like Redact, but for strings
+func (r *redactor) RedactString(s string) (string, int) {
+	out, count := r.Redact([]byte(s))
+	return string(out), count
+}
+
+// RedactResponse redacts the output and header values
+// of resp in place, returning the number of replacements
+func (r *redactor) RedactResponse(resp *response) int {
+	var count, n int
+
+	resp.out, count = r.Redact(resp.out)
+
+	for name, values := range resp.headers {
+		for i, v := range values {
+			values[i], n = r.RedactString(v)
+			count += n
+		}
+		resp.headers[name] = values
+	}
+
+	return count
+}
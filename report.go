@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// a reportRow is a single output file in a report
+type reportRow struct {
+	Domain     string
+	URL        string
+	Status     int
+	Title      string
+	Size       int
+	Tags       []string
+	Path       string
+	Screenshot template.URL
+}
+
+// a reportDomain is the per-domain summary in a report
+type reportDomain struct {
+	Name  string
+	Total int
+
+	// percentages of the total for each status class,
+	// in the order of statusClasses
+	Classes []float64
+}
+
+// statusClasses are the groups of status codes that are
+// charted for each domain; anything else is "other"
+var statusClasses = []string{"2xx", "3xx", "4xx", "5xx", "other"}
+
+// statusClass returns the index in statusClasses for a status
+func statusClass(status int) int {
+	if status >= 200 && status < 600 {
+		return status/100 - 2
+	}
+	return len(statusClasses) - 1
+}
+
+// runReport implements the report subcommand, which turns
+// an output directory into a single self-contained HTML file
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: concurl report [-o report.html] <outdir>\n")
+		fs.PrintDefaults()
+	}
+
+	var outFile string
+	fs.StringVar(&outFile, "o", "report.html", "Report file")
+
+	// allow the flags to come after the output dir too
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+
+	files, encrypted, err := readOutputDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var rows []reportRow
+	counts := make(map[string][]int)
+
+	for _, f := range files {
+		row := reportRow{
+			Domain: f.domain,
+			URL:    f.URL(),
+			Status: f.Status(),
+			Title:  f.Title(),
+			Size:   f.Size(),
+			Tags:   f.meta["tag"],
+			Path:   f.path,
+		}
+
+		// screenshots taken by other tools can be included
+		// by saving them next to the output file
+		if png, err := ioutil.ReadFile(f.path + ".png"); err == nil {
+			row.Screenshot = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		}
+
+		rows = append(rows, row)
+
+		if counts[f.domain] == nil {
+			counts[f.domain] = make([]int, len(statusClasses))
+		}
+		counts[f.domain][statusClass(row.Status)]++
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Domain != rows[j].Domain {
+			return rows[i].Domain < rows[j].Domain
+		}
+		return rows[i].URL < rows[j].URL
+	})
+
+	var domains []reportDomain
+	for name, c := range counts {
+		d := reportDomain{Name: name}
+		for _, n := range c {
+			d.Total += n
+		}
+		for _, n := range c {
+			d.Classes = append(d.Classes, 100*float64(n)/float64(d.Total))
+		}
+		domains = append(domains, d)
+	}
+
+	// the busiest domains are the most interesting
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].Total != domains[j].Total {
+			return domains[i].Total > domains[j].Total
+		}
+		return domains[i].Name < domains[j].Name
+	})
+
+	out, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+
+	err = reportTemplate.Execute(out, map[string]interface{}{
+		"Dir":       dir,
+		"Rows":      rows,
+		"Domains":   domains,
+		"Classes":   statusClasses,
+		"Encrypted": encrypted,
+	})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %d results\n", outFile, len(rows))
+	return nil
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>concurl report: {{.Dir}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { cursor: pointer; background: #f4f4f4; user-select: none; }
+td.num { text-align: right; }
+.tag { display: inline-block; background: #e8eefc; border-radius: 3px; padding: 0 4px; margin: 1px; font-size: 0.9em; }
+.bar { display: flex; height: 14px; width: 400px; background: #eee; }
+.c0 { background: #4caf50; } .c1 { background: #2196f3; } .c2 { background: #ff9800; } .c3 { background: #f44336; } .c4 { background: #9e9e9e; }
+.legend span { display: inline-block; width: 10px; height: 10px; margin: 0 4px 0 12px; }
+img.shot { max-width: 200px; max-height: 150px; }
+#filter { margin: 1em 0; padding: 4px; width: 300px; }
+</style>
+</head>
+<body>
+<h1>concurl report</h1>
+<p>{{len .Rows}} results from <code>{{.Dir}}</code>{{if .Encrypted}}; {{.Encrypted}} encrypted output files were not included{{end}}</p>
+
+<h2>Domains</h2>
+<p class="legend">{{range $i, $c := .Classes}}<span class="c{{$i}}"></span>{{$c}}{{end}}</p>
+<table>
+<tr><th>Domain</th><th>Results</th><th>Statuses</th></tr>
+{{range .Domains}}<tr>
+<td>{{.Name}}</td>
+<td class="num">{{.Total}}</td>
+<td><div class="bar">{{range $i, $pct := .Classes}}{{if $pct}}<div class="c{{$i}}" style="width: {{printf "%.2f" $pct}}%"></div>{{end}}{{end}}</div></td>
+</tr>
+{{end}}</table>
+
+<h2>Results</h2>
+<input id="filter" placeholder="Filter results...">
+<table id="results">
+<thead><tr><th>Domain</th><th>URL</th><th>Status</th><th>Title</th><th>Size</th><th>Tags</th><th>File</th><th>Screenshot</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr>
+<td>{{.Domain}}</td>
+<td><a href="{{.URL}}">{{.URL}}</a></td>
+<td class="num">{{.Status}}</td>
+<td>{{.Title}}</td>
+<td class="num">{{.Size}}</td>
+<td>{{range .Tags}}<span class="tag">{{.}}</span>{{end}}</td>
+<td><code>{{.Path}}</code></td>
+<td>{{if .Screenshot}}<img class="shot" src="{{.Screenshot}}">{{end}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+
+<script>
+(function() {
+	var table = document.getElementById("results");
+	var body = table.tBodies[0];
+
+	Array.prototype.forEach.call(table.tHead.rows[0].cells, function(th, col) {
+		var asc = true;
+		th.addEventListener("click", function() {
+			var rows = Array.prototype.slice.call(body.rows);
+			rows.sort(function(a, b) {
+				var x = a.cells[col].textContent, y = b.cells[col].textContent;
+				var nx = parseFloat(x), ny = parseFloat(y);
+				var cmp = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+				return asc ? cmp : -cmp;
+			});
+			asc = !asc;
+			rows.forEach(function(r) { body.appendChild(r); });
+		});
+	});
+
+	document.getElementById("filter").addEventListener("input", function(e) {
+		var q = e.target.value.toLowerCase();
+		Array.prototype.forEach.call(body.rows, function(r) {
+			r.style.display = r.textContent.toLowerCase().indexOf(q) === -1 ? "none" : "";
+		});
+	});
+})();
+</script>
+</body>
+</html>
+`))
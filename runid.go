@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// newRunID returns a random (version 4) UUID
+// to identify a run by
+func newRunID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// a manifest records the details of a run, so that traffic
+// seen by target owners can be tied back to it
+type manifest struct {
+	RunID   string    `json:"run_id"`
+	Header  string    `json:"header"`
+	Started time.Time `json:"started"`
+	Host    string    `json:"host"`
+	Args    []string  `json:"args"`
+}
+
+// saveManifest saves the manifest for a run in the root of
+// the store, named after the run so that resumed runs in the
+// same output dir don't overwrite each other's manifests
+func saveManifest(st store, m manifest, red *redactor, enc *encrypter) (string, error) {
+	m.Args = scrubArgs(m.Args)
+	if red != nil {
+		args := make([]string, len(m.Args))
+		for i, arg := range m.Args {
+			args[i], _ = red.RedactString(arg)
+		}
+		m.Args = args
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	data = append(data, '\n')
+
+	name := "manifest-" + m.RunID + ".json"
+	if enc != nil {
+		data, err = enc.Encrypt(data)
+		if err != nil {
+			return "", err
+		}
+		name += ".age"
+	}
+
+	return st.Save("", name, data)
+}
+
+// secretOptions are the options, of concurl and of curl, that
+// take credentials (e.g. webhook URLs, which are all it takes
+// to post to a channel), so their values are never recorded
+var secretOptions = []string{
+	"-alert-webhook", "--alert-webhook",
+	"-u", "--user", "-U", "--proxy-user", "--oauth2-bearer",
+	"-b", "--cookie", "-E", "--cert", "--pass",
+}
+
+// secretHeaders are the request headers whose values are
+// credentials
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// scrubArgs returns a copy of a run's arguments with the values
+// of secretOptions and secretHeaders replaced, whether they're
+// separate arguments, attached with an = or (for short options)
+// attached directly
+func scrubArgs(args []string) []string {
+	out := append([]string{}, args...)
+
+	for i := 0; i < len(out); i++ {
+		arg := out[i]
+
+		if arg == "-H" || arg == "--header" {
+			if i+1 < len(out) {
+				i++
+				out[i] = scrubHeader(out[i])
+			}
+			continue
+		}
+		if strings.HasPrefix(arg, "-H") {
+			out[i] = "-H" + scrubHeader(arg[2:])
+			continue
+		}
+
+		for _, o := range secretOptions {
+			if arg == o {
+				if i+1 < len(out) {
+					i++
+					out[i] = string(redacted)
+				}
+				break
+			}
+			if strings.HasPrefix(arg, o+"=") {
+				out[i] = o + "=" + string(redacted)
+				break
+			}
+			if len(o) == 2 && strings.HasPrefix(arg, o) {
+				out[i] = o + string(redacted)
+				break
+			}
+		}
+	}
+
+	return out
+}
+
+// scrubHeader returns a header with its value replaced
+// if it is one of secretHeaders
+func scrubHeader(h string) string {
+	parts := strings.SplitN(h, ":", 2)
+	for _, name := range secretHeaders {
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), name) {
+			return parts[0] + ": " + string(redacted)
+		}
+	}
+	return h
+}
+
+// hostname returns the name of the machine a run is on
+func hostname() string {
+	h, _ := os.Hostname()
+	return h
+}
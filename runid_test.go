@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScrubArgs(t *testing.T) {
+	cases := []struct {
+		in, want []string
+	}{
+		{nil, []string{}},
+		{[]string{"-c", "5", "-d", "100"}, []string{"-c", "5", "-d", "100"}},
+		{[]string{"-alert-webhook", "https://hooks.slack.com/services/T/B/x"}, []string{"-alert-webhook", "[REDACTED]"}},
+		{[]string{"--alert-webhook=https://discord.com/api/webhooks/1/x"}, []string{"--alert-webhook=[REDACTED]"}},
+		{[]string{"-alert-webhook"}, []string{"-alert-webhook"}},
+		{[]string{"--", "-u", "bob:hunter2"}, []string{"--", "-u", "[REDACTED]"}},
+		{[]string{"-ubob:hunter2"}, []string{"-u[REDACTED]"}},
+		{[]string{"--user", "bob:hunter2", "-s"}, []string{"--user", "[REDACTED]", "-s"}},
+		{[]string{"-H", "Authorization: Bearer abc"}, []string{"-H", "Authorization: [REDACTED]"}},
+		{[]string{"-Hcookie:a=b"}, []string{"-Hcookie: [REDACTED]"}},
+		{[]string{"--header", "X-Foo: bar"}, []string{"--header", "X-Foo: bar"}},
+		{[]string{"-o", "out", "-run-id-header", "X-Scan-Id"}, []string{"-o", "out", "-run-id-header", "X-Scan-Id"}},
+	}
+
+	for _, c := range cases {
+		if got := scrubArgs(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("scrubArgs(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+
+	// the arguments passed in are left alone
+	args := []string{"-u", "bob:hunter2"}
+	scrubArgs(args)
+	if args[1] != "bob:hunter2" {
+		t.Errorf("scrubArgs changed its argument to %q", args)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// a statusFilter is a list of status ranges, parsed from
+// patterns like 200-299,401,403, that a status matches
+// if it's in any one of them
+type statusFilter []statusRange
+
+// a statusRange is an inclusive range of status codes
+type statusRange struct {
+	lo, hi int
+}
+
+// parseStatusFilter parses a comma separated list of
+// status patterns (see parseStatusRange)
+func parseStatusFilter(spec string) (statusFilter, error) {
+	var f statusFilter
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		r, err := parseStatusRange(p)
+		if err != nil {
+			return nil, err
+		}
+		f = append(f, r)
+	}
+	if len(f) == 0 {
+		return nil, fmt.Errorf("no statuses in %q", spec)
+	}
+	return f, nil
+}
+
+// parseStatusRange parses a status pattern: a single status
+// like 500, a class like 5xx or a range like 401-403
+func parseStatusRange(pattern string) (statusRange, error) {
+	v := strings.ToLower(pattern)
+
+	if len(v) == 3 && strings.HasSuffix(v, "xx") {
+		class, err := strconv.Atoi(v[:1])
+		if err != nil {
+			return statusRange{}, fmt.Errorf("invalid status %q", pattern)
+		}
+		return statusRange{class * 100, class*100 + 99}, nil
+	}
+
+	if parts := strings.SplitN(v, "-", 2); len(parts) == 2 {
+		lo, err1 := strconv.Atoi(parts[0])
+		hi, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil || lo > hi {
+			return statusRange{}, fmt.Errorf("invalid status %q", pattern)
+		}
+		return statusRange{lo, hi}, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return statusRange{}, fmt.Errorf("invalid status %q", pattern)
+	}
+	return statusRange{n, n}, nil
+}
+
+// Match reports whether a status matches the filter
+func (f statusFilter) Match(status int) bool {
+	for _, r := range f {
+		if status >= r.lo && status <= r.hi {
+			return true
+		}
+	}
+	return false
+}
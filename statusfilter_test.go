@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatusRange(t *testing.T) {
+	cases := []struct {
+		in   string
+		want statusRange
+		err  bool
+	}{
+		{"500", statusRange{500, 500}, false},
+		{"5xx", statusRange{500, 599}, false},
+		{"2XX", statusRange{200, 299}, false},
+		{"401-403", statusRange{401, 403}, false},
+		{"403-403", statusRange{403, 403}, false},
+		{"403-401", statusRange{}, true},
+		{"x", statusRange{}, true},
+		{"xx", statusRange{}, true},
+		{"xxx", statusRange{}, true},
+		{"5x", statusRange{}, true},
+		{"50x", statusRange{}, true},
+		{"-500", statusRange{}, true},
+		{"401-", statusRange{}, true},
+		{"401-4xx", statusRange{}, true},
+		{"", statusRange{}, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseStatusRange(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseStatusRange(%q): expected an error, got %v", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseStatusRange(%q): %s", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseStatusRange(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestStatusFilter(t *testing.T) {
+	f, err := parseStatusFilter("200-299, 401,403,5xx,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := statusFilter{{200, 299}, {401, 401}, {403, 403}, {500, 599}}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("got %v, want %v", f, want)
+	}
+
+	cases := []struct {
+		status int
+		want   bool
+	}{
+		{199, false},
+		{200, true},
+		{299, true},
+		{301, false},
+		{401, true},
+		{402, false},
+		{403, true},
+		{500, true},
+		{599, true},
+		{600, false},
+		{0, false},
+	}
+
+	for _, c := range cases {
+		if got := f.Match(c.status); got != c.want {
+			t.Errorf("Match(%d) = %v, want %v", c.status, got, c.want)
+		}
+	}
+
+	for _, spec := range []string{"", ",", "200,x"} {
+		if _, err := parseStatusFilter(spec); err == nil {
+			t.Errorf("parseStatusFilter(%q): expected an error", spec)
+		}
+	}
+}
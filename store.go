@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// a store saves output files, grouped by domain
+type store interface {
+	// Save saves data as name for domain, replacing
+	// anything already saved with that name, and
+	// returns the location it was saved to
+	Save(domain, name string, data []byte) (string, error)
+
+	// SaveUnique is like Save, but adds a numeric
+	// suffix to name if it has already been taken
+	SaveUnique(domain, name string, data []byte) (string, error)
+
+	// Exists reports whether name has been saved for domain
+	Exists(domain, name string) (bool, error)
+}
+
+// newStore returns the store described by spec, or a
+// store for the local output directory if spec is empty
+func newStore(spec, outputDir string) (store, error) {
+	if spec == "" {
+		return localStore{root: outputDir}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "ssh", "sftp":
+		return newSSHStore(u)
+	}
+
+	return nil, fmt.Errorf("unsupported store %q", spec)
+}
+
+// a localStore saves output files in a directory
+// on the local filesystem
+type localStore struct {
+	root string
+}
+
+func (s localStore) dir(domain string) (string, error) {
+	dir := filepath.Join(s.root, domain)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return "", fmt.Errorf("failed to create output dir: %s", err)
+		}
+	}
+	return dir, nil
+}
+
+func (s localStore) Save(domain, name string, data []byte) (string, error) {
+	dir, err := s.dir(domain)
+	if err != nil {
+		return "", err
+	}
+
+	// write to a temporary file and rename it into place so
+	// that an interrupted run can't leave a partial file that
+	// a resumed run would mistake for a finished one
+	tmp, err := writeTemp(dir, name, data)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+
+	p := filepath.Join(dir, name)
+	return p, os.Rename(tmp, p)
+}
+
+func (s localStore) SaveUnique(domain, name string, data []byte) (string, error) {
+	dir, err := s.dir(domain)
+	if err != nil {
+		return "", err
+	}
+	return writeUnique(dir, name, data)
+}
+
+// writeTemp writes data to a new hidden temporary file in dir
+// for name, readable by everyone like other output files, and
+// returns its path. The caller should remove it once it's been
+// moved or linked into place
+func writeTemp(dir, name string, data []byte) (string, error) {
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp-")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func (s localStore) Exists(domain, name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.root, domain, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
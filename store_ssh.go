@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// an sshStore saves output files to a directory on a remote
+// host using the sftp command line utility. Files are uploaded
+// under a temporary name and then renamed into place so that
+// partially uploaded files are never mistaken for results.
+// Every sftp session shares a single SSH connection, so that
+// workers don't each log in for every file they save
+type sshStore struct {
+	sync.Mutex
+	dest string
+	port string
+	root string
+
+	// the control socket for the shared connection,
+	// and a slot for each session using it at once
+	control  string
+	sessions chan struct{}
+
+	// listings of the files that were in each domain's
+	// dir when it was first checked, to save connecting
+	// to the remote host for every file
+	listings map[string]map[string]bool
+}
+
+// newSSHStore returns an *sshStore for a URL like
+// ssh://user@host:port/path. Authentication is left
+// to ssh: keys, agents and ssh_config all apply
+func newSSHStore(u *url.URL) (*sshStore, error) {
+	if u.Hostname() == "" {
+		return nil, errors.New("ssh store needs a host")
+	}
+
+	dest := u.Hostname()
+	if u.User != nil {
+		dest = u.User.Username() + "@" + dest
+	}
+
+	root := u.Path
+	if root == "" {
+		root = "."
+	}
+
+	s := &sshStore{
+		dest:     dest,
+		port:     u.Port(),
+		root:     root,
+		control:  filepath.Join(os.TempDir(), "concurl-ssh-"+randomHex(8)),
+		sessions: make(chan struct{}, maxSSHSessions),
+		listings: make(map[string]map[string]bool),
+	}
+
+	// create the root dir up front so that problems
+	// connecting show up before any requests are made.
+	// This also sets up the shared connection before
+	// the workers start trying to use it
+	var cmds []string
+	for _, dir := range parentDirs(root) {
+		cmds = append(cmds, "-mkdir "+sftpQuote(dir))
+	}
+	if _, err := s.batch(cmds); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *sshStore) Save(domain, name string, data []byte) (string, error) {
+	return s.save(domain, name, data, false)
+}
+
+func (s *sshStore) SaveUnique(domain, name string, data []byte) (string, error) {
+	return s.save(domain, name, data, true)
+}
+
+func (s *sshStore) save(domain, name string, data []byte, unique bool) (string, error) {
+	local, err := ioutil.TempFile("", "concurl-upload-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(local.Name())
+
+	_, err = local.Write(data)
+	if cerr := local.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	dir := path.Join(s.root, domain)
+	tmp := path.Join(dir, "."+randomHex(8)+".tmp")
+	final := path.Join(dir, name)
+
+	cmds := []string{
+		"-mkdir " + sftpQuote(dir),
+		"put " + sftpQuote(local.Name()) + " " + sftpQuote(tmp),
+	}
+
+	if !unique {
+		// rename replaces an existing file atomically
+		// when the server supports posix-rename
+		cmds = append(cmds, "rename "+sftpQuote(tmp)+" "+sftpQuote(final))
+		_, err = s.batch(cmds)
+		if err != nil {
+			s.batch([]string{"-rm " + sftpQuote(tmp)})
+		}
+		return s.location(final), err
+	}
+
+	// hard linking fails if the target already exists, so
+	// it can be used to claim a name without overwriting
+	// a file saved by another worker. Each failure costs a
+	// connection, but names are rarely taken many times
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 0; i < 100; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		final = path.Join(dir, candidate)
+
+		cmds = append(cmds, "ln "+sftpQuote(tmp)+" "+sftpQuote(final))
+		_, err = s.batch(cmds)
+		if err == nil {
+			break
+		}
+
+		// sftp doesn't say why linking failed, so only
+		// try the next name if this one is really taken
+		if _, lerr := s.batch([]string{"ls " + sftpQuote(final)}); lerr != nil {
+			break
+		}
+
+		// the upload only needs doing once
+		cmds = nil
+	}
+
+	s.batch([]string{"-rm " + sftpQuote(tmp)})
+	return s.location(final), err
+}
+
+func (s *sshStore) Exists(domain, name string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	listing, ok := s.listings[domain]
+	if !ok {
+		// a missing dir just means nothing's been saved yet.
+		// Hidden files are listed for the output markers
+		out, err := s.batch([]string{"-ls -1a " + sftpQuote(path.Join(s.root, domain))})
+		if err != nil {
+			return false, err
+		}
+
+		listing = make(map[string]bool)
+		for _, line := range strings.Split(string(out), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "sftp>") {
+				continue
+			}
+			listing[path.Base(line)] = true
+		}
+		s.listings[domain] = listing
+	}
+
+	return listing[name], nil
+}
+
+// location returns a URL for a remote path
+func (s *sshStore) location(p string) string {
+	host := s.dest
+	if s.port != "" {
+		host += ":" + s.port
+	}
+	return "ssh://" + host + "/" + strings.TrimPrefix(p, "/")
+}
+
+// maxSSHSessions is the most sftp sessions that are run over the
+// shared connection at once; sshd refuses more than MaxSessions,
+// which is 10 by default
+const maxSSHSessions = 8
+
+// batch runs sftp with the provided batch commands, which
+// abort the batch on failure unless they are prefixed with -,
+// and returns its output
+func (s *sshStore) batch(cmds []string) ([]byte, error) {
+	s.sessions <- struct{}{}
+	defer func() { <-s.sessions }()
+
+	// the first session becomes the master for the shared
+	// connection, which is kept open for a while after the
+	// last session using it ends
+	args := []string{
+		"-q", "-b", "-",
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + s.control,
+		"-o", "ControlPersist=60",
+	}
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+	args = append(args, s.dest)
+
+	cmd := exec.Command("sftp", args...)
+	cmd.Stdin = strings.NewReader(strings.Join(cmds, "\n") + "\n")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("sftp failed: %s: %s", err, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+// parentDirs returns p and each of its parent directories,
+// starting with the one closest to the root
+func parentDirs(p string) []string {
+	var dirs []string
+	for p != "." && p != "/" && p != "" {
+		dirs = append([]string{p}, dirs...)
+		p = path.Dir(p)
+	}
+	return dirs
+}
+
+// sftpQuote quotes an argument for an sftp batch command
+func sftpQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
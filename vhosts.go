@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// a job is a single request to be made by a worker
+type job struct {
+	url string
+
+	// extra arguments for curl, e.g. a Host header
+	args []string
+
+	// set for requests made as part of vhost bruteforcing;
+	// baseline is shared by the request for the URL as
+	// given and the requests for each vhost
+	vhost    string
+	baseline *baseline
+}
+
+// origin returns the URL the job was created for, which can
+// be different to the URL requested when bruteforcing vhosts
+func (j job) origin() string {
+	if j.baseline != nil {
+		return j.baseline.url
+	}
+	return j.url
+}
+
+// isBaseline reports whether the job is for the baseline
+// response that vhost responses are compared against
+func (j job) isBaseline() bool {
+	return j.baseline != nil && j.vhost == ""
+}
+
+// a baseline is the response for a URL without a vhost,
+// which the responses for each vhost are compared against
+type baseline struct {
+	url  string
+	done chan struct{}
+
+	ok     bool
+	status int
+	hash   uint64
+}
+
+// newBaseline returns a new *baseline for a URL
+func newBaseline(u string) *baseline {
+	return &baseline{url: u, done: make(chan struct{})}
+}
+
+// set records the baseline response and unblocks any calls
+// to Similarity. It must be called exactly once, even if
+// the baseline request failed
+func (b *baseline) set(resp response, err error) {
+	if err == nil {
+		b.ok = true
+		b.status = resp.status
+		b.hash = simhash(resp.out)
+	}
+	close(b.done)
+}
+
+// Similarity waits for the baseline response and returns how
+// similar resp is to it, between 0 and 1. Responses with a
+// different status to the baseline, or for which there is
+// no baseline, are not considered similar at all
+func (b *baseline) Similarity(resp response) float64 {
+	<-b.done
+
+	if !b.ok || b.status != resp.status {
+		return 0
+	}
+	return similarity(b.hash, simhash(resp.out))
+}
+
+// vhostJobs returns a job to fetch the baseline for u and
+// a job for each of the vhosts. By default only the Host
+// header is changed; when sni is true the vhost is also
+// used for TLS SNI, with curl connecting to the host from
+// u regardless of what the vhost resolves to
+func vhostJobs(u string, vhosts []string, sni bool) []job {
+	b := newBaseline(u)
+	jobs := []job{{url: u, baseline: b}}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		// curl will fail for the baseline too
+		sni = false
+	}
+
+	for _, vhost := range vhosts {
+		if !sni {
+			jobs = append(jobs, job{
+				url:      u,
+				args:     []string{"-H", "Host: " + vhost},
+				vhost:    vhost,
+				baseline: b,
+			})
+			continue
+		}
+
+		port := urlPort(parsed)
+
+		// curl wants IPv6 addresses in brackets
+		host := parsed.Hostname()
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := *parsed
+		target.Host = vhost
+		if parsed.Port() != "" {
+			target.Host = net.JoinHostPort(vhost, port)
+		}
+
+		jobs = append(jobs, job{
+			url:      target.String(),
+			args:     []string{"--connect-to", vhost + ":" + port + ":" + host + ":" + port},
+			vhost:    vhost,
+			baseline: b,
+		})
+	}
+
+	return jobs
+}
+
+// urlPort returns the port for a URL, which is
+// the default for the scheme if there isn't one
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
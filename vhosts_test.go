@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVhostJobs(t *testing.T) {
+	cases := []struct {
+		u    string
+		sni  bool
+		url  string
+		args []string
+	}{
+		{"https://203.0.113.10/", false, "https://203.0.113.10/", []string{"-H", "Host: admin.example.com"}},
+		{"https://203.0.113.10/", true, "https://admin.example.com/", []string{"--connect-to", "admin.example.com:443:203.0.113.10:443"}},
+		{"http://203.0.113.10/a?b=1", true, "http://admin.example.com/a?b=1", []string{"--connect-to", "admin.example.com:80:203.0.113.10:80"}},
+		{"https://203.0.113.10:8443/", true, "https://admin.example.com:8443/", []string{"--connect-to", "admin.example.com:8443:203.0.113.10:8443"}},
+		{"https://[2001:db8::1]/", true, "https://admin.example.com/", []string{"--connect-to", "admin.example.com:443:[2001:db8::1]:443"}},
+		{"http://[2001:db8::1]:8080/", true, "http://admin.example.com:8080/", []string{"--connect-to", "admin.example.com:8080:[2001:db8::1]:8080"}},
+		{"https://origin.example.com/", true, "https://admin.example.com/", []string{"--connect-to", "admin.example.com:443:origin.example.com:443"}},
+		{"://bad", true, "://bad", []string{"-H", "Host: admin.example.com"}},
+	}
+
+	for _, c := range cases {
+		jobs := vhostJobs(c.u, []string{"admin.example.com"}, c.sni)
+		if len(jobs) != 2 {
+			t.Errorf("vhostJobs(%q, %v) returned %d jobs, want 2", c.u, c.sni, len(jobs))
+			continue
+		}
+
+		// the baseline comes first, for the URL as given
+		base := jobs[0]
+		if !base.isBaseline() || base.url != c.u || base.args != nil || base.origin() != c.u {
+			t.Errorf("vhostJobs(%q, %v) baseline = %+v", c.u, c.sni, base)
+		}
+
+		j := jobs[1]
+		if j.url != c.url || !reflect.DeepEqual(j.args, c.args) {
+			t.Errorf("vhostJobs(%q, %v) = %q %q, want %q %q", c.u, c.sni, j.url, j.args, c.url, c.args)
+		}
+		if j.isBaseline() || j.vhost != "admin.example.com" || j.baseline != base.baseline || j.origin() != c.u {
+			t.Errorf("vhostJobs(%q, %v) vhost job = %+v", c.u, c.sni, j)
+		}
+	}
+}
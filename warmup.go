@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a warmer checks that each host accepts connections before
+// any requests are made to it, so that the requests for dead
+// or blocking hosts can be skipped rather than each waiting
+// for curl to give up on them
+type warmer struct {
+	sync.Mutex
+	timeout time.Duration
+	hosts   map[string]*warmup
+}
+
+// a warmup is the result of warming up a single host
+type warmup struct {
+	once sync.Once
+	err  error
+}
+
+// newWarmer returns a new *warmer that gives up
+// on connections after the provided timeout
+func newWarmer(timeout time.Duration) *warmer {
+	return &warmer{
+		timeout: timeout,
+		hosts:   make(map[string]*warmup),
+	}
+}
+
+// Check connects to the host for u (completing a TLS handshake
+// for https URLs) the first time it is called for that scheme,
+// host and port, and returns the error from doing so. Later
+// calls for the same host wait for the first to finish and
+// return the same error without connecting again. Connecting
+// is given up on if ctx is cancelled. URLs that aren't http or
+// https, or that don't have a host (e.g. example.com without a
+// scheme, which curl guesses one for), aren't warmed up
+func (w *warmer) Check(ctx context.Context, u *url.URL) error {
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+
+	addr := net.JoinHostPort(u.Hostname(), urlPort(u))
+	key := u.Scheme + "://" + addr
+
+	w.Lock()
+	h, ok := w.hosts[key]
+	if !ok {
+		h = &warmup{}
+		w.hosts[key] = h
+	}
+	w.Unlock()
+
+	h.once.Do(func() {
+		h.err = w.connect(ctx, u.Scheme, addr)
+	})
+	return h.err
+}
+
+// warmupOverrides are the curl options that change where
+// requests are sent, which warm-up connections can't follow
+var warmupOverrides = []string{
+	"-x", "--proxy", "--preproxy",
+	"--socks4", "--socks4a", "--socks5", "--socks5-hostname",
+	"--connect-to", "--resolve", "--unix-socket", "--abstract-unix-socket",
+}
+
+// warmupBypass returns the curl option or proxy environment
+// variable that means requests won't go directly to the hosts
+// they're for, or an empty string if there isn't one
+func warmupBypass(args []string) string {
+	for _, arg := range args {
+		for _, o := range warmupOverrides {
+			// short options can have their value attached
+			if arg == o || (len(o) == 2 && strings.HasPrefix(arg, o)) {
+				return o
+			}
+		}
+	}
+
+	for _, name := range []string{"http_proxy", "https_proxy", "HTTPS_PROXY", "all_proxy", "ALL_PROXY"} {
+		if os.Getenv(name) != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func (w *warmer) connect(ctx context.Context, scheme, addr string) error {
+	dialer := &net.Dialer{Timeout: w.timeout}
+
+	if scheme != "https" {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// certificate problems are for curl to decide about; all
+	// that matters here is whether the handshake completes
+	dialer.Deadline = time.Now().Add(w.timeout)
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{InsecureSkipVerify: true}}
+	conn, err := tlsDialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// a wireTracer saves curl's trace of the raw bytes sent and
+// received for a sample of requests, for debugging protocol
+// level problems. Traces are redacted and encrypted in the
+// same way as output files
+type wireTracer struct {
+	dir       string
+	sample    float64
+	dataLimit int
+	red       *redactor
+	enc       *encrypter
+}
+
+// Sample reports whether a request should be traced
+func (t *wireTracer) Sample() bool {
+	return rand.Float64() < t.sample
+}
+
+// Start returns a *traceWriter for curl's trace of a request
+// to be written to. It should be passed to Finish once curl
+// has exited
+func (t *wireTracer) Start() *traceWriter {
+	return newTraceWriter(t.dataLimit, t.red)
+}
+
+// Finish saves the trace written to w as name in the trace dir
+func (t *wireTracer) Finish(w *traceWriter, name string) error {
+	data := w.Bytes()
+	if t.enc != nil {
+		var err error
+		data, err = t.enc.Encrypt(data)
+		if err != nil {
+			return err
+		}
+		name += ".age"
+	}
+
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(t.dir, name), data, 0644)
+}
+
+// a traceWriter cleans up a trace written by curl with --trace
+// as it's written. Sections of the trace start with a line like:
+//
+//	<= Recv data, 1256 bytes (0x4e8)
+//
+// followed by lines of 16 bytes in hex and then as text, each
+// prefixed with its offset into the section (e.g. "0010: "). The
+// data of each section is put back together before it's redacted,
+// so that secrets split over several lines are still found, and
+// everything after the first limit bytes of data sent and of data
+// received is removed. The data is then split into lines again
+type traceWriter struct {
+	limit int
+	red   *redactor
+
+	out     bytes.Buffer
+	partial []byte
+
+	// the section being written and its data so far
+	header string
+	kind   string
+	data   []byte
+
+	// bytes of data kept so far in each direction
+	seen map[string]int
+}
+
+// traceSlack is how much data past the limit is redacted
+const traceSlack = 1024
+
+// newTraceWriter returns a new *traceWriter
+func newTraceWriter(limit int, red *redactor) *traceWriter {
+	return &traceWriter{
+		limit: limit,
+		red:   red,
+		seen:  make(map[string]int),
+	}
+}
+
+// Write implements io.Writer
+func (w *traceWriter) Write(p []byte) (int, error) {
+	data := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			break
+		}
+		w.line(string(data[:i]))
+		data = data[i+1:]
+	}
+	w.partial = append([]byte{}, data...)
+	return len(p), nil
+}
+
+// Bytes finishes the trace and returns it
+func (w *traceWriter) Bytes() []byte {
+	if len(w.partial) > 0 {
+		w.line(string(w.partial))
+		w.partial = nil
+	}
+	w.flush()
+	return w.out.Bytes()
+}
+
+// line handles a single line of the trace
+func (w *traceWriter) line(line string) {
+	if strings.HasPrefix(line, "=> ") || strings.HasPrefix(line, "<= ") || strings.HasPrefix(line, "== ") {
+		w.flush()
+		w.out.WriteString(line + "\n")
+
+		if !strings.HasPrefix(line, "== ") {
+			w.header = line
+		}
+		if strings.HasPrefix(line, "=> Send data") || strings.HasPrefix(line, "<= Recv data") {
+			w.kind = line[:2]
+		}
+		return
+	}
+
+	_, data, ok := parseTraceLine(line)
+	if !ok || w.header == "" {
+		w.flush()
+		w.out.WriteString(line + "\n")
+		return
+	}
+
+	w.data = append(w.data, data...)
+
+	// a body should never be held on to in full, but a bit
+	// more than is kept is needed to redact secrets that
+	// are cut off by the limit
+	if keep := w.limit - w.seen[w.kind] + traceSlack; w.kind != "" && len(w.data) > keep {
+		w.data = w.data[:keep]
+	}
+}
+
+// flush writes out the data of the current section
+func (w *traceWriter) flush() {
+	if w.header == "" {
+		return
+	}
+
+	data := w.data
+	if w.red != nil {
+		data, _ = w.red.Redact(data)
+	}
+
+	truncated := false
+	if w.kind != "" {
+		remaining := w.limit - w.seen[w.kind]
+		if len(data) > remaining {
+			data = data[:remaining]
+			truncated = true
+		}
+		w.seen[w.kind] += len(data)
+	}
+
+	writeTraceData(&w.out, data)
+	if truncated {
+		w.out.WriteString("[truncated]\n")
+	}
+
+	w.header, w.kind, w.data = "", "", nil
+}
+
+// parseTraceLine returns the offset and data of a line
+// of data in a trace, if it is one
+func parseTraceLine(line string) (int, string, bool) {
+	i := strings.Index(line, ": ")
+	if i == -1 {
+		return 0, "", false
+	}
+
+	offset, err := strconv.ParseUint(line[:i], 16, 32)
+	if err != nil {
+		return 0, "", false
+	}
+
+	// each byte is two hex digits and a space, and lines
+	// that are short of a full width are padded with spaces
+	hex := line[i+2:]
+	var data []byte
+	for j := 0; j < traceWidth*3 && j+3 <= len(hex) && hex[j+2] == ' '; j += 3 {
+		b, err := strconv.ParseUint(hex[j:j+2], 16, 8)
+		if err != nil {
+			break
+		}
+		data = append(data, byte(b))
+	}
+	if len(data) == 0 {
+		return 0, "", false
+	}
+	return int(offset), string(data), true
+}
+
+// traceWidth is the number of bytes on each line of data
+const traceWidth = 16
+
+// writeTraceData writes data as lines of traceWidth bytes in
+// hex followed by the same bytes as text, with anything that
+// isn't printable as a dot, prefixed with their offsets, as
+// curl does with --trace
+func writeTraceData(out *bytes.Buffer, data []byte) {
+	for i := 0; i < len(data); i += traceWidth {
+		line := data[i:]
+		if len(line) > traceWidth {
+			line = line[:traceWidth]
+		}
+
+		fmt.Fprintf(out, "%04x: ", i)
+		for c := 0; c < traceWidth; c++ {
+			if c < len(line) {
+				fmt.Fprintf(out, "%02x ", line[c])
+			} else {
+				out.WriteString("   ")
+			}
+		}
+
+		for _, b := range line {
+			if b < 0x20 || b >= 0x80 {
+				b = '.'
+			}
+			out.WriteByte(b)
+		}
+		out.WriteByte('\n')
+	}
+}
+
+// traceName returns the name of the trace file for
+// an attempt at the request with the provided filename
+func traceName(filename string, attempt int) string {
+	return fmt.Sprintf("%s.%d.trace", filename, attempt)
+}
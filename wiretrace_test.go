@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// traceHeader returns the line that starts a section of a
+// trace with the provided data, as written by curl with --trace
+func traceHeader(direction, kind, data string) string {
+	return fmt.Sprintf("%s %s, %d bytes (0x%x)\n", direction, kind, len(data), len(data))
+}
+
+// traceSection returns a section of a trace with the provided data
+func traceSection(direction, kind, data string) string {
+	out := &bytes.Buffer{}
+	out.WriteString(traceHeader(direction, kind, data))
+	writeTraceData(out, []byte(data))
+	return out.String()
+}
+
+const (
+	sampleRequest = "GET /p HTTP/1.1\r\nHost: 127.0.0.1:18082\r\nUser-Agent: curl/7.88.1\r\nAccept: */*\r\n" +
+		"Cookie: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaabob@evil.com\r\n\r\n"
+	sampleBody = "lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor /p\x00\xff\xc3\xa9"
+)
+
+// sampleTrace is a trace as written by curl with --trace
+var sampleTrace = "== Info:   Trying 127.0.0.1:8080...\n" +
+	traceSection("=>", "Send header", sampleRequest) +
+	traceSection("<=", "Recv header", "HTTP/1.0 200 OK\r\n") +
+	traceSection("<=", "Recv header", "\r\n") +
+	traceSection("<=", "Recv data", sampleBody) +
+	"== Info: Closing connection 0\n"
+
+// cleanTrace runs raw through a traceWriter, chunk bytes at a time
+func cleanTrace(raw string, limit int, red *redactor, chunk int) string {
+	w := newTraceWriter(limit, red)
+	for len(raw) > 0 {
+		n := chunk
+		if n > len(raw) {
+			n = len(raw)
+		}
+		w.Write([]byte(raw[:n]))
+		raw = raw[n:]
+	}
+	return string(w.Bytes())
+}
+
+// traceData returns the data of each section of a trace
+func traceData(trace string) []string {
+	var sections []string
+	for _, line := range strings.Split(trace, "\n") {
+		if strings.HasPrefix(line, "=> ") || strings.HasPrefix(line, "<= ") {
+			sections = append(sections, "")
+			continue
+		}
+		if _, data, ok := parseTraceLine(line); ok && len(sections) > 0 {
+			sections[len(sections)-1] += data
+		}
+	}
+	return sections
+}
+
+func TestTraceWriterUnchanged(t *testing.T) {
+	// however it's written, a trace with nothing to redact
+	// or truncate should come out just as it went in
+	for _, chunk := range []int{1, 7, 64, len(sampleTrace)} {
+		if got := cleanTrace(sampleTrace, 1024, nil, chunk); got != sampleTrace {
+			t.Errorf("writing %d bytes at a time, got:\n%s\nwant:\n%s", chunk, got, sampleTrace)
+		}
+	}
+
+	// the raw bytes can be recovered from it
+	got := traceData(sampleTrace)
+	if len(got) != 4 || got[0] != sampleRequest || got[3] != sampleBody {
+		t.Errorf("got data %q, want the request and body", got)
+	}
+}
+
+func TestTraceWriterRedact(t *testing.T) {
+	red := &redactor{patterns: []*regexp.Regexp{
+		regexp.MustCompile(`a+[a-z.]+@[a-z]+\.com`),
+		regexp.MustCompile(`ipsum.*tempor`),
+	}}
+
+	got := traceData(cleanTrace(sampleTrace, 1024, red, 13))
+	if len(got) != 4 {
+		t.Fatalf("got %d sections, want 4", len(got))
+	}
+
+	// secrets split over several lines are still found
+	if want := strings.Replace(sampleRequest, strings.Repeat("a", 70)+"bob@evil.com", "[REDACTED]", 1); got[0] != want {
+		t.Errorf("got request %q, want %q", got[0], want)
+	}
+	if want := "lorem [REDACTED] /p\x00\xff\xc3\xa9"; got[3] != want {
+		t.Errorf("got body %q, want %q", got[3], want)
+	}
+}
+
+func TestTraceWriterTruncate(t *testing.T) {
+	got := cleanTrace(sampleTrace, 10, nil, 64)
+
+	lines := &bytes.Buffer{}
+	writeTraceData(lines, []byte("lorem ipsu"))
+
+	want := traceHeader("<=", "Recv data", sampleBody) + lines.String() + "[truncated]\n== Info: Closing connection 0\n"
+	if !strings.HasSuffix(got, want) {
+		t.Errorf("got:\n%s\nwant it to end with:\n%s", got, want)
+	}
+
+	// headers aren't truncated
+	if data := traceData(got); len(data) == 0 || data[0] != sampleRequest {
+		t.Errorf("headers were truncated:\n%s", got)
+	}
+
+	// the limit is for all of the data in each direction
+	twice := strings.Replace(sampleTrace, "== Info: Closing", traceSection("<=", "Recv data", "more.")+"== Info: Closing", 1)
+	got = cleanTrace(twice, len(sampleBody), nil, 64)
+	if !strings.Contains(got, "<= Recv data, 5 bytes (0x5)\n[truncated]\n") {
+		t.Errorf("second section wasn't truncated:\n%s", got)
+	}
+}
+
+func TestWriteTraceData(t *testing.T) {
+	pad := func(n int) string { return strings.Repeat("   ", n) }
+
+	cases := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"abc", "0000: 61 62 63 " + pad(13) + "abc\n"},
+		{"\r\n\x00\xff", "0000: 0d 0a 00 ff " + pad(12) + "....\n"},
+		{"tab\there~", "0000: 74 61 62 09 68 65 72 65 7e " + pad(7) + "tab.here~\n"},
+		{strings.Repeat("x", 17), "0000: " + strings.Repeat("78 ", 16) + strings.Repeat("x", 16) + "\n0010: 78 " + pad(15) + "x\n"},
+	}
+
+	for _, c := range cases {
+		out := &bytes.Buffer{}
+		writeTraceData(out, []byte(c.in))
+
+		if got := out.String(); got != c.want {
+			t.Errorf("writeTraceData(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseTraceLine(t *testing.T) {
+	cases := []struct {
+		in     string
+		offset int
+		data   string
+		ok     bool
+	}{
+		{"0000: 47 45 54 20 2f 20 48 54 54 50 2f 31 2e 31 0d 0a GET / HTTP/1.1..", 0, "GET / HTTP/1.1\r\n", true},
+		{"0010: 0a                                              .", 0x10, "\n", true},
+		{"0040: 63 63 65 70 74 3a 20 2a 2f 2a 0d 0a 0d 0a       ccept: */*....", 0x40, "ccept: */*\r\n\r\n", true},
+		{"0050: 61 62 63 64 65 66 20 31 32 20 61 62 20 63 64 20 abcdef 12 ab cd ", 0x50, "abcdef 12 ab cd ", true},
+		{"10000: ff ..", 0x10000, "\xff", true},
+		{"0000: GET / HTTP/1.1", 0, "", false},
+		{"0000: ", 0, "", false},
+		{"Host: example.com", 0, "", false},
+		{"[truncated]", 0, "", false},
+	}
+
+	for _, c := range cases {
+		offset, data, ok := parseTraceLine(c.in)
+		if offset != c.offset || data != c.data || ok != c.ok {
+			t.Errorf("parseTraceLine(%q) = %d, %q, %v, want %d, %q, %v", c.in, offset, data, ok, c.offset, c.data, c.ok)
+		}
+	}
+}